@@ -0,0 +1,136 @@
+package cacheclient
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// HashType values accepted in redis.json
+const (
+	HashTypeRing     = "ring"
+	HashTypeCluster  = "cluster"
+	HashTypeSentinel = "sentinel"
+	HashTypeSingle   = "single"
+)
+
+// backend is the part of go-redis used by CacheClient. It is satisfied by
+// *redis.Ring, *redis.ClusterClient and *redis.Client (single node or
+// sentinel failover), so every CacheClient method works the same no matter
+// which one redis.json asks for.
+type backend interface {
+	redis.Cmdable
+	Pipeline() redis.Pipeliner
+	PoolStats() *redis.PoolStats
+	Publish(channel, message string) *redis.IntCmd
+	Subscribe(channels ...string) *redis.PubSub
+	Close() error
+}
+
+// newBackend build the go-redis client selected by c.HashType
+func newBackend(c *Config) (backend, error) {
+	switch c.HashType {
+	case "", HashTypeRing:
+		addrs := make(map[string]string)
+		if err := parseStringsToMap(c.Addrs, addrs); err != nil {
+			return nil, err
+		}
+
+		return redis.NewRing(&redis.RingOptions{
+			Addrs:              addrs,
+			HeartbeatFrequency: time.Duration(c.HeartbeatFrequency),
+			DB:                 c.DB,
+			Password:           c.Password,
+			MaxRetries:         c.MaxRetries,
+
+			DialTimeout:  time.Duration(c.ConnTimeout.DialTimeout),
+			ReadTimeout:  time.Duration(c.ConnTimeout.ReadTimeout),
+			WriteTimeout: time.Duration(c.ConnTimeout.WriteTimeout),
+
+			PoolSize:           c.Pool.PoolSize,
+			PoolTimeout:        time.Duration(c.Pool.PoolTimeout),
+			IdleTimeout:        time.Duration(c.Pool.IdleTimeout),
+			IdleCheckFrequency: time.Duration(c.Pool.IdleCheckFrequency),
+		}), nil
+
+	case HashTypeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:      hostPorts(c.Addrs),
+			Password:   c.Password,
+			MaxRetries: c.MaxRetries,
+
+			DialTimeout:  time.Duration(c.ConnTimeout.DialTimeout),
+			ReadTimeout:  time.Duration(c.ConnTimeout.ReadTimeout),
+			WriteTimeout: time.Duration(c.ConnTimeout.WriteTimeout),
+
+			PoolSize:           c.Pool.PoolSize,
+			PoolTimeout:        time.Duration(c.Pool.PoolTimeout),
+			IdleTimeout:        time.Duration(c.Pool.IdleTimeout),
+			IdleCheckFrequency: time.Duration(c.Pool.IdleCheckFrequency),
+		}), nil
+
+	case HashTypeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    c.MasterName,
+			SentinelAddrs: hostPorts(c.Addrs),
+			DB:            c.DB,
+			Password:      c.Password,
+			MaxRetries:    c.MaxRetries,
+
+			DialTimeout:  time.Duration(c.ConnTimeout.DialTimeout),
+			ReadTimeout:  time.Duration(c.ConnTimeout.ReadTimeout),
+			WriteTimeout: time.Duration(c.ConnTimeout.WriteTimeout),
+
+			PoolSize:           c.Pool.PoolSize,
+			PoolTimeout:        time.Duration(c.Pool.PoolTimeout),
+			IdleTimeout:        time.Duration(c.Pool.IdleTimeout),
+			IdleCheckFrequency: time.Duration(c.Pool.IdleCheckFrequency),
+		}), nil
+
+	case HashTypeSingle:
+		var addr string
+		if addrs := hostPorts(c.Addrs); len(addrs) > 0 {
+			addr = addrs[0]
+		}
+
+		return redis.NewClient(&redis.Options{
+			Addr:       addr,
+			DB:         c.DB,
+			Password:   c.Password,
+			MaxRetries: c.MaxRetries,
+
+			DialTimeout:  time.Duration(c.ConnTimeout.DialTimeout),
+			ReadTimeout:  time.Duration(c.ConnTimeout.ReadTimeout),
+			WriteTimeout: time.Duration(c.ConnTimeout.WriteTimeout),
+
+			PoolSize:           c.Pool.PoolSize,
+			PoolTimeout:        time.Duration(c.Pool.PoolTimeout),
+			IdleTimeout:        time.Duration(c.Pool.IdleTimeout),
+			IdleCheckFrequency: time.Duration(c.Pool.IdleCheckFrequency),
+		}), nil
+	}
+
+	return nil, fmt.Errorf("cache: unknown HashType %q", c.HashType)
+}
+
+// hostPorts return the host:port part of Addrs entries, so the ring style
+// "name:host:port" entries can be reused for cluster, sentinel and single.
+// An entry is "name:" and an address only when the rest is a host:port, so
+// IPv6 hosts like "[::1]:6379" are kept.
+func hostPorts(nodes []string) []string {
+	addrs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if _, _, err := net.SplitHostPort(node); err != nil {
+			if i := strings.Index(node, ":"); i > 0 {
+				if _, _, err := net.SplitHostPort(node[i+1:]); err == nil {
+					node = node[i+1:]
+				}
+			}
+		}
+		addrs = append(addrs, node)
+	}
+	return addrs
+}
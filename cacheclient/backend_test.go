@@ -0,0 +1,51 @@
+package cacheclient
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_newBackend(t *testing.T) {
+	c := Config{Addrs: []string{"server1:127.0.0.1:6379"}, MasterName: "mymaster"}
+
+	c.HashType = ""
+	client, err := newBackend(&c)
+	if _, ok := client.(*redis.Ring); err != nil || !ok {
+		t.Error("default HashType should build Ring", client, err)
+	}
+	client.Close()
+
+	c.HashType = HashTypeCluster
+	client, err = newBackend(&c)
+	if _, ok := client.(*redis.ClusterClient); err != nil || !ok {
+		t.Error("cluster HashType should build ClusterClient", client, err)
+	}
+	client.Close()
+
+	c.HashType = HashTypeSingle
+	client, err = newBackend(&c)
+	if cl, ok := client.(*redis.Client); err != nil || !ok || cl.Options().Addr != "127.0.0.1:6379" {
+		t.Error("single HashType should build Client", client, err)
+	}
+	client.Close()
+
+	c.HashType = "consistent"
+	_, err = newBackend(&c)
+	if err == nil {
+		t.Error("unknown HashType should fail")
+	}
+}
+
+func Test_hostPorts(t *testing.T) {
+	addrs := hostPorts([]string{"server1:192.168.4.41:6379", "192.168.4.41:6380"})
+
+	if len(addrs) != 2 || addrs[0] != "192.168.4.41:6379" || addrs[1] != "192.168.4.41:6380" {
+		t.Error("hostPorts error", addrs)
+	}
+
+	addrs = hostPorts([]string{"[::1]:6379", "server1:[::1]:6380", "[fe80::1%eth0]:6381"})
+	if len(addrs) != 3 || addrs[0] != "[::1]:6379" || addrs[1] != "[::1]:6380" || addrs[2] != "[fe80::1%eth0]:6381" {
+		t.Error("hostPorts should keep IPv6 hosts", addrs)
+	}
+}
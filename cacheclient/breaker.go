@@ -0,0 +1,281 @@
+package cacheclient
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrShardUnavailable is returned without calling Redis when the breaker of
+// the shard of a key is open, the caller may go to the database directly
+var ErrShardUnavailable = errors.New("cache: shard unavailable")
+
+// defaults of BreakerConfig
+const (
+	defaultBreakerMinCalls      = 20
+	defaultBreakerErrorRate     = 0.5
+	defaultBreakerOpenTime      = 5 * time.Second
+	defaultBreakerHalfOpenCalls = 3
+)
+
+// breakerState is the state of a shard breaker: closed lets all calls
+// through, open rejects them, half-open lets a few trial calls through
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = [...]string{"closed", "open", "half-open"}
+
+func (s breakerState) String() string {
+	return breakerStateNames[s]
+}
+
+// breaker is the circuit breaker of one shard. The calls are counted in
+// fixed windows, the breaker opens when the failed or slow calls of a
+// window reach their rate.
+type breaker struct {
+	mu          sync.Mutex
+	state       breakerState
+	windowStart time.Time
+	calls       int
+	failures    int
+	slow        int
+	openedAt    time.Time
+	// trials admitted and passed while half-open
+	trials int
+	passed int
+
+	rejected int64
+}
+
+// breakerConfig return c with the defaults filled
+func breakerConfig(c BreakerConfig) BreakerConfig {
+	if c.MinCalls == 0 {
+		c.MinCalls = defaultBreakerMinCalls
+	}
+	if c.ErrorRate == 0 {
+		c.ErrorRate = defaultBreakerErrorRate
+	}
+	if c.OpenTime == 0 {
+		c.OpenTime = Duration(defaultBreakerOpenTime)
+	}
+	if c.HalfOpenCalls == 0 {
+		c.HalfOpenCalls = defaultBreakerHalfOpenCalls
+	}
+	return c
+}
+
+// allow tell whether a call may go to the shard, an open breaker turns
+// half-open after OpenTime
+func (b *breaker) allow(c BreakerConfig, now time.Time) (ok bool, changed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && now.Sub(b.openedAt) >= time.Duration(c.OpenTime) {
+		b.state = breakerHalfOpen
+		b.trials, b.passed = 0, 0
+		changed = true
+	}
+
+	switch b.state {
+	case breakerOpen:
+	case breakerHalfOpen:
+		if b.trials < c.HalfOpenCalls {
+			b.trials++
+			return true, changed
+		}
+	default:
+		return true, changed
+	}
+	atomic.AddInt64(&b.rejected, 1)
+	return false, changed
+}
+
+// record count a call of d, it returns true when the state changed
+func (b *breaker) record(c BreakerConfig, now time.Time, d time.Duration, failed bool) bool {
+	slow := c.SlowCall > 0 && d > time.Duration(c.SlowCall)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		// a call started before the breaker opened
+		return false
+
+	case breakerHalfOpen:
+		if failed || slow {
+			b.open(now)
+			return true
+		}
+		b.passed++
+		if b.passed < c.HalfOpenCalls {
+			return false
+		}
+		b.state = breakerClosed
+		b.reset(now)
+		return true
+	}
+
+	if now.Sub(b.windowStart) >= time.Duration(c.Window) {
+		b.reset(now)
+	}
+	b.calls++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+	if b.calls < c.MinCalls {
+		return false
+	}
+	if float64(b.failures) >= c.ErrorRate*float64(b.calls) ||
+		(c.SlowRate > 0 && float64(b.slow) >= c.SlowRate*float64(b.calls)) {
+		b.open(now)
+		return true
+	}
+	return false
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+}
+
+func (b *breaker) reset(now time.Time) {
+	b.windowStart = now
+	b.calls, b.failures, b.slow = 0, 0, 0
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// shardBreaker return the breaker of shard and the config with defaults,
+// the breaker is nil when breakers are disabled or the shard is unknown
+func (cc *CacheClient) shardBreaker(shard string) (*breaker, BreakerConfig) {
+	c := cc.config().Breaker
+	if c.Window <= 0 || shard == "" {
+		return nil, c
+	}
+
+	cc.breakers.mu.RLock()
+	b, ok := cc.breakers.m[shard]
+	cc.breakers.mu.RUnlock()
+	if !ok {
+		cc.breakers.mu.Lock()
+		if b, ok = cc.breakers.m[shard]; !ok {
+			if cc.breakers.m == nil {
+				cc.breakers.m = make(map[string]*breaker)
+			}
+			b = new(breaker)
+			cc.breakers.m[shard] = b
+		}
+		cc.breakers.mu.Unlock()
+	}
+	return b, breakerConfig(c)
+}
+
+// allow return ErrShardUnavailable when the breaker of shard is open
+func (cc *CacheClient) allow(shard string) error {
+	b, c := cc.shardBreaker(shard)
+	if b == nil {
+		return nil
+	}
+	ok, changed := b.allow(c, time.Now())
+	if changed {
+		log.Printf("cache: breaker of shard %s is half-open", shard)
+	}
+	if !ok {
+		return ErrShardUnavailable
+	}
+	return nil
+}
+
+// record count a call of shard in its breaker, redis.Nil is a miss but not
+// a failure
+func (cc *CacheClient) record(shard string, d time.Duration, err error) {
+	b, c := cc.shardBreaker(shard)
+	if b == nil {
+		return
+	}
+	failed := err != nil && err != redis.Nil
+	if b.record(c, time.Now(), d, failed) {
+		log.Printf("cache: breaker of shard %s is %s", shard, b.current())
+	}
+}
+
+// breakerStats add the breaker states and rejected calls to the shards of
+// st
+func (cc *CacheClient) breakerStats(st *Stats) {
+	if cc.config().Breaker.Window <= 0 {
+		return
+	}
+
+	cc.breakers.mu.RLock()
+	defer cc.breakers.mu.RUnlock()
+	for shard, b := range cc.breakers.m {
+		if st.Shards == nil {
+			st.Shards = make(map[string]ShardStats)
+		}
+		s := st.Shards[shard]
+		s.Breaker = b.current().String()
+		s.Rejected = atomic.LoadInt64(&b.rejected)
+		st.Shards[shard] = s
+	}
+}
+
+// allowKeys ask the breakers of the shards of keys once each, it returns
+// the shard of each key let through and the rejected keys. All keys are let
+// through when breakers are disabled, with a nil shards map.
+func (cc *CacheClient) allowKeys(client *generation, keys []string) (shards map[string]string, rejected []string) {
+	if cc.config().Breaker.Window <= 0 {
+		return nil, nil
+	}
+
+	shards = make(map[string]string, len(keys))
+	allowed := make(map[string]error)
+	for _, key := range keys {
+		shard := client.locate(key)
+		err, ok := allowed[shard]
+		if !ok {
+			err = cc.allow(shard)
+			allowed[shard] = err
+		}
+		if err != nil {
+			rejected = append(rejected, key)
+			continue
+		}
+		shards[key] = shard
+	}
+	return shards, rejected
+}
+
+// recordBatch record a batch as one call of each shard in shards, failed
+// when a key of the shard failed
+func (cc *CacheClient) recordBatch(shards map[string]string, d time.Duration, keyErr func(key string) error) {
+	errs := make(map[string]error)
+	for key, shard := range shards {
+		err := keyErr(key)
+		if err == redis.Nil {
+			err = nil
+		}
+		if prev, ok := errs[shard]; !ok || prev == nil {
+			errs[shard] = err
+		}
+	}
+	for shard, err := range errs {
+		cc.record(shard, d, err)
+	}
+}
@@ -0,0 +1,115 @@
+package cacheclient
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_breaker(t *testing.T) {
+	c := breakerConfig(BreakerConfig{
+		Window:        Duration(time.Minute),
+		MinCalls:      4,
+		OpenTime:      Duration(time.Second),
+		HalfOpenCalls: 2,
+	})
+	now := time.Now()
+	var b breaker
+
+	b.record(c, now, 0, false)
+	b.record(c, now, 0, true)
+	b.record(c, now, 0, false)
+	if b.record(c, now, 0, true) != true || b.current() != breakerOpen {
+		t.Fatal("breaker should open at ErrorRate", b.current())
+	}
+	if ok, _ := b.allow(c, now.Add(time.Second/2)); ok || b.rejected != 1 {
+		t.Error("open breaker should reject calls")
+	}
+
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(c, now); !ok || b.current() != breakerHalfOpen {
+			t.Fatal("half-open breaker should let trial calls through", i)
+		}
+	}
+	if ok, _ := b.allow(c, now); ok {
+		t.Error("half-open breaker should reject calls above HalfOpenCalls")
+	}
+	if b.record(c, now, 0, true) != true || b.current() != breakerOpen {
+		t.Fatal("failed trial should open the breaker again")
+	}
+
+	now = now.Add(time.Second)
+	b.allow(c, now)
+	b.allow(c, now)
+	b.record(c, now, 0, false)
+	if b.record(c, now, 0, false) != true || b.current() != breakerClosed {
+		t.Error("passed trials should close the breaker", b.current())
+	}
+}
+
+func Test_breaker_SlowCalls(t *testing.T) {
+	c := breakerConfig(BreakerConfig{
+		Window:   Duration(time.Minute),
+		MinCalls: 2,
+		SlowCall: Duration(100 * time.Millisecond),
+		SlowRate: 1,
+	})
+	now := time.Now()
+	var b breaker
+
+	b.record(c, now, time.Second, false)
+	b.record(c, now, time.Millisecond, false)
+	if b.current() != breakerClosed {
+		t.Error("breaker should stay closed below SlowRate")
+	}
+
+	// a new window
+	now = now.Add(time.Minute)
+	b.record(c, now, time.Second, false)
+	b.record(c, now, time.Second, false)
+	if b.current() != breakerOpen {
+		t.Error("breaker should open at SlowRate")
+	}
+}
+
+// server is down, calls fail fast once the breaker is open
+func Test_Breaker(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithBreaker(time.Minute, 3, 0.5, time.Minute))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	for i := 0; i < 3; i++ {
+		if err = cc.Get("key1").Err(); err == nil || err == ErrShardUnavailable {
+			t.Fatal("Get should fail with the server error", err)
+		}
+	}
+
+	if err = cc.Get("key1").Err(); err != ErrShardUnavailable {
+		t.Error("Get should fail fast", err)
+	}
+	if err = cc.SetWithTTL("key1", "v", time.Minute); err != ErrShardUnavailable {
+		t.Error("Set should fail fast", err)
+	}
+	if _, err = cc.Del("key1"); err != ErrShardUnavailable {
+		t.Error("Del should fail fast", err)
+	}
+	if _, err = cc.Gets([]string{"key1", "key2"}); err != ErrShardUnavailable {
+		t.Error("Gets should fail fast", err)
+	}
+	if err = cc.SetsWithTTL(map[string]interface{}{"key1": "v"}, time.Minute); err != ErrShardUnavailable {
+		t.Error("Sets should fail fast", err)
+	}
+
+	st := cc.Snapshot()
+	s := st.Shards["127.0.0.1:1"]
+	if s.Breaker != "open" || s.Rejected != 5 || s.Calls != 3 {
+		t.Error("breaker stats error", s)
+	}
+	if st.Ops["Get"].Calls != 4 || st.Ops["Get"].Errors != 4 {
+		t.Error("rejected calls should be counted as errors", st.Ops["Get"])
+	}
+}
@@ -2,7 +2,13 @@ package cacheclient
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"errors"
@@ -11,73 +17,196 @@ import (
 
 // CacheClient ...
 type CacheClient struct {
-	ring  *redis.Ring
+	mu     sync.RWMutex
+	conf   Config
+	client *generation
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	loads        loadGroup
+	near         *nearCache
+	invalidation invalidation
+	hot          *hotKeys
+	replicas     replicas
+	reporter     reporter
+	health       health
+
+	breakers struct {
+		mu sync.RWMutex
+		m  map[string]*breaker
+	}
+
 	stats struct {
 		hits      uint64
 		misses    uint64
-		request   int64
-		elapse    int64
+		ops       [numOps]opCounters
 		timeStart int64
+
+		shardMu sync.RWMutex
+		shards  map[string]*shardCounters
+
+		compressIn  int64
+		compressOut int64
+
+		l1Hits   uint64
+		l1Misses uint64
+		stale    uint64
+
+		earlyRefreshes uint64
+		tombstoneHits  uint64
 	}
+	// window is the stats window of GetStats
+	window *StatsWindow
 }
 
-// InitPackage init all handling about package
-func InitPackage(confPath string) {
-	initConf(confPath)
+// InitPackage init all handling about package, it returns the error of
+// loading or validating the config file
+func InitPackage(confPath string) error {
+	return initConf(confPath)
 }
 
-// NewCacheClient create new cache client for caller
+// NewCacheClient create new cache client for caller, with the package
+// config loaded by InitPackage
 func NewCacheClient() (*CacheClient, error) {
-	cc := &CacheClient{}
-
-	addrs := make(map[string]string)
-	parseStringsToMap(conf.Addrs, addrs)
-
-	cc.ring = redis.NewRing(&redis.RingOptions{
-		Addrs:              addrs,
-		HeartbeatFrequency: conf.HeartbeatFrequency * time.Second,
-		DB:                 conf.DB,
-		Password:           conf.Password,
-		MaxRetries:         conf.MaxRetries,
-
-		DialTimeout:  conf.ConnTimeout.DialTimeout * time.Second,
-		ReadTimeout:  conf.ConnTimeout.ReadTimeout * time.Second,
-		WriteTimeout: conf.ConnTimeout.WriteTimeout * time.Second,
-
-		PoolSize:           conf.Pool.PoolSize,
-		PoolTimeout:        conf.Pool.PoolTimeout * time.Second,
-		IdleTimeout:        conf.Pool.IdleTimeout * time.Second,
-		IdleCheckFrequency: conf.Pool.IdleCheckFrequency * time.Second,
-	})
+	return NewCacheClientWithConfig(conf)
+}
+
+// NewCacheClientWithConfig create new cache client with its own config,
+// opts are applied on a copy of cfg
+func NewCacheClientWithConfig(cfg Config, opts ...Option) (*CacheClient, error) {
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	cc := &CacheClient{conf: cfg, done: make(chan struct{})}
+	cc.near = newNearCache(cfg.NearCache)
+	cc.hot = newHotKeys(cfg.HotKeys)
+	cc.replicas.setKeys(cfg.HotKeys.Keys)
+
+	client, err := newGeneration(&cc.conf)
+	if err != nil {
+		return nil, err
+	}
+	cc.client = client
 
 	cc.stats.timeStart = time.Now().UnixNano()
+	cc.window = &StatsWindow{cc: cc, prev: Stats{EndTime: cc.stats.timeStart}}
+
+	cc.invalidation.id = newLockToken()
+	cc.startInvalidation()
+	cc.startReporter()
 
 	return cc, nil
 }
 
-// Get get string from cache
+// Close stop the config watcher, the stats reporter and the heartbeat, and
+// release all connections of the client
+func (cc *CacheClient) Close() error {
+	var err error
+	cc.closeOnce.Do(func() {
+		close(cc.done)
+		cc.resubscribe()
+		cc.stopReporter()
+		cc.stopHealth()
+
+		cc.mu.RLock()
+		err = cc.client.Close()
+		cc.mu.RUnlock()
+	})
+	return err
+}
+
+// Get get string from cache, from the near cache first if enabled
 func (cc *CacheClient) Get(key string) *redis.StringCmd {
-	start := time.Now().UnixNano()
-	b := cc.ring.Get(key)
-	atomic.AddInt64(&cc.stats.elapse, time.Now().UnixNano()-start)
-	if b.Err() != nil {
-		atomic.AddUint64(&cc.stats.misses, 1)
-	} else {
+	nc := cc.nearCache()
+	if nc != nil {
+		if v, ok := nc.get(key); ok {
+			atomic.AddUint64(&cc.stats.l1Hits, 1)
+			return redis.NewStringResult(v, nil)
+		}
+		atomic.AddUint64(&cc.stats.l1Misses, 1)
+	}
+
+	client := cc.acquire()
+	defer client.release()
+
+	cc.recordKeys(key)
+	shard := client.locate(key)
+	start := time.Now()
+	if err := cc.allow(shard); err != nil {
+		cc.count(opGet, "", start, err)
+		return redis.NewStringResult("", err)
+	}
+	var token uint64
+	if nc != nil {
+		token = nc.begin(key)
+	}
+	b, shard := cc.get(client, key, shard)
+	cc.count(opGet, shard, start, b.Err())
+	if nc != nil {
+		// skipped when key is written or invalidated during the read
+		nc.fill(key, token, b.Val(), b.Err() == nil)
+	}
+	switch b.Err() {
+	case nil:
 		atomic.AddUint64(&cc.stats.hits, 1)
+	case redis.Nil:
+		atomic.AddUint64(&cc.stats.misses, 1)
 	}
-	atomic.AddInt64(&cc.stats.request, 1)
 	return b
 }
 
-// Set set string to cache
+// expireSeconds convert the int expire of the old API, which is seconds
+func expireSeconds(expire int) time.Duration {
+	return time.Duration(expire) * time.Second
+}
+
+// Set set string to cache, expire is in seconds, 0 means no expire
+//
+// Deprecated: use SetWithTTL
 func (cc *CacheClient) Set(key string, value interface{}, expire int) error {
-	start := time.Now().UnixNano()
-	err := cc.ring.Set(key, value, time.Duration(expire)).Err()
+	return cc.SetWithTTL(key, value, expireSeconds(expire))
+}
+
+// SetWithTTL set string to cache, ttl is changed by the TTL policy of
+// the config. ttl 0 means the policy default, or no expire without one.
+func (cc *CacheClient) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return cc.set(key, value, cc.ttlFor(key, ttl))
+}
+
+// set write key with the final ttl
+func (cc *CacheClient) set(key string, value interface{}, ttl time.Duration) error {
+	client := cc.acquire()
+	defer client.release()
+
+	cc.recordKeys(key)
+	shard := client.locate(key)
+	start := time.Now()
+	if err := cc.allow(shard); err != nil {
+		cc.nearDel(key)
+		cc.count(opSet, "", start, err)
+		return err
+	}
+	err := client.Set(key, value, ttl).Err()
+	// copies are written after the key, see fillCopies
+	pipe := client.Pipeline()
+	if err != nil {
+		execCopies(pipe, cc.updateCopies(pipe, client, key, nil, 0))
+	} else {
+		execCopies(pipe, cc.updateCopies(pipe, client, key, value, ttl))
+	}
 	if err != nil {
 		log.Printf("cache: Set key=%q failed: %s", key, err)
+		cc.nearDel(key)
+	} else {
+		cc.nearSet(key, value, ttl)
+		cc.publishInvalidation(key)
 	}
-	atomic.AddInt64(&cc.stats.elapse, time.Now().UnixNano()-start)
-	atomic.AddInt64(&cc.stats.request, 1)
+	cc.count(opSet, shard, start, err)
 	return err
 }
 
@@ -86,45 +215,79 @@ func (cc *CacheClient) GetString(key string) (string, error) {
 	return cc.Get(key).Result()
 }
 
-// SetString set string to cache
+// SetString set string to cache, expire is in seconds
+//
+// Deprecated: use SetStringWithTTL
 func (cc *CacheClient) SetString(key string, value string, expire int) error {
-	err := cc.Set(key, value, expire)
+	return cc.SetStringWithTTL(key, value, expireSeconds(expire))
+}
+
+// SetStringWithTTL set string to cache
+func (cc *CacheClient) SetStringWithTTL(key string, value string, ttl time.Duration) error {
+	err := cc.SetWithTTL(key, value, ttl)
 	return err
 }
 
-// GetObject get object from cache
+// GetObject get object from cache, a stale value is returned as well, use
+// GetObjectStale to tell. A key cached as missing returns ErrNotFoundCached,
+// a key not in cache redis.Nil.
 func (cc *CacheClient) GetObject(key string, object interface{}) error {
-	b, err := cc.Get(key).Bytes()
-	if err != nil {
-		return err
-	}
+	_, err := cc.GetObjectStale(key, object)
+	return err
+}
 
-	if err = json.Unmarshal(b, object); err != nil {
-		log.Printf("cache: key=%q Unmarshal(%T) failed: %s", key, object, err)
-		return err
-	}
+// SetObject set object to cache, expire is in seconds
+//
+// Deprecated: use SetObjectWithTTL
+func (cc *CacheClient) SetObject(key string, object interface{}, expire int) error {
+	return cc.SetObjectWithTTL(key, object, expireSeconds(expire))
+}
 
-	return nil
+// SetObjectWithTTL set object to cache with the codec of the client
+func (cc *CacheClient) SetObjectWithTTL(key string, object interface{}, ttl time.Duration) error {
+	return cc.SetObjectWithCodec(key, object, ttl, cc.codec())
 }
 
-// SetObject set object to cache, object
-func (cc *CacheClient) SetObject(key string, object interface{}, expire int) error {
-	b, err := json.Marshal(object)
+// SetObjectWithCodec set object to cache with codec, which must be a
+// built-in codec or one added by RegisterCodec
+func (cc *CacheClient) SetObjectWithCodec(key string, object interface{}, ttl time.Duration, codec Codec) error {
+	b, err := cc.encode(key, codec, object)
 	if err != nil {
-		log.Printf("cache: Marshal key=%q failed: %s", key, err)
 		return err
 	}
 
-	err = cc.Set(key, b, expire)
+	v, ttl := cc.staleWrite(b, cc.ttlFor(key, ttl), 0)
+	err = cc.set(key, v, ttl)
 	return err
 }
 
+// codec return the codec named by the config
+func (cc *CacheClient) codec() Codec {
+	c, _ := codecNamed(cc.config().Codec)
+	return c
+}
+
 // Del by key
 func (cc *CacheClient) Del(key string) (int64, error) {
-	start := time.Now().UnixNano()
-	result, err := cc.ring.Del(key).Result()
-	atomic.AddInt64(&cc.stats.request, 1)
-	atomic.AddInt64(&cc.stats.elapse, time.Now().UnixNano()-start)
+	client := cc.acquire()
+	defer client.release()
+
+	cc.nearDel(key)
+
+	cc.recordKeys(key)
+	shard := client.locate(key)
+	start := time.Now()
+	if err := cc.allow(shard); err != nil {
+		cc.count(opDel, "", start, err)
+		return 0, err
+	}
+	result, err := client.Del(key).Result()
+	// copies are on other shards, one DEL each after the key
+	pipe := client.Pipeline()
+	execCopies(pipe, cc.updateCopies(pipe, client, key, nil, 0))
+	cc.count(opDel, shard, start, err)
+	// published even on error, the key may have been deleted
+	cc.publishInvalidation(key)
 	if err != nil {
 		log.Printf("cache: Del key=%q failed: %s", key, err)
 		return 0, err
@@ -134,7 +297,10 @@ func (cc *CacheClient) Del(key string) (int64, error) {
 
 // Test key is exist
 func (cc *CacheClient) exists(key string) (int64, error) {
-	result, err := cc.ring.Exists(key).Result()
+	client := cc.acquire()
+	defer client.release()
+
+	result, err := client.Exists(key).Result()
 	if err != nil {
 		log.Printf("cache: Del key=%q failed: %s", key, err)
 		return result, err
@@ -143,40 +309,251 @@ func (cc *CacheClient) exists(key string) (int64, error) {
 }
 
 // Batch processing
-// Gets get strings from cache
+// Gets get strings from cache, a missed key has a cmd with redis.Nil error.
+// It only fails when no key can be read.
 func (cc *CacheClient) Gets(keys []string) (map[string]*redis.StringCmd, error) {
 	if len(keys) <= 0 {
 		return nil, errors.New("keys is empty")
 	}
-	pipe := cc.ring.Pipeline()
+	client := cc.acquire()
+	defer client.release()
+
+	start := time.Now()
+	res := make(map[string]*redis.StringCmd)
+	nc := cc.nearCache()
+	remote := keys
+	if nc != nil {
+		remote = make([]string, 0, len(keys))
+		for _, key := range keys {
+			if v, ok := nc.get(key); ok {
+				atomic.AddUint64(&cc.stats.l1Hits, 1)
+				res[key] = redis.NewStringResult(v, nil)
+				continue
+			}
+			atomic.AddUint64(&cc.stats.l1Misses, 1)
+			remote = append(remote, key)
+		}
+		if len(remote) == 0 {
+			cc.count(opGets, "", start, nil)
+			return res, nil
+		}
+	}
+
+	cc.recordKeys(remote...)
+	var failed int
+	var firstErr error
+	// keys of a shard whose breaker is open are not read
+	shards, rejected := cc.allowKeys(client, remote)
+	for _, key := range rejected {
+		res[key] = redis.NewStringResult("", ErrShardUnavailable)
+		failed++
+		firstErr = ErrShardUnavailable
+	}
+
+	pipe := client.Pipeline()
 	pipelineCmds := make(map[string]*redis.StringCmd)
-	for _, key := range keys {
-		pipelineCmds[key] = pipe.Get(key)
+	tokens := make(map[string]uint64)
+	// hot keys are read from a random copy whose shard breaker lets it
+	// through, the read is counted against the copy shard
+	copies := make(map[string]string)
+	copyAllowed := make(map[string]error)
+	for _, key := range remote {
+		if _, ok := shards[key]; shards != nil && !ok {
+			continue
+		}
+		readKey := key
+		if keys := cc.readCopies(client, key); len(keys) > 0 {
+			if i := rand.Intn(len(keys) + 1); i > 0 {
+				copyShard := client.locate(keys[i-1])
+				err, ok := copyAllowed[copyShard]
+				if !ok {
+					err = cc.allow(copyShard)
+					copyAllowed[copyShard] = err
+				}
+				if err == nil {
+					readKey = keys[i-1]
+					copies[key] = readKey
+				}
+			}
+		}
+		pipelineCmds[key] = pipe.Get(readKey)
+		if nc != nil {
+			tokens[key] = nc.begin(key)
+		}
 	}
-	_, err := pipe.Exec()
-	if err != nil {
-		return nil, err
+	// per key errors are checked below, redis.Nil of a missed key is not
+	// an error of the batch
+	pipe.Exec()
+	copyShards := make(map[string]string, len(copies))
+	copyCmds := make(map[string]*redis.StringCmd, len(copies))
+	for key, copyKey := range copies {
+		copyShards[key], copyCmds[key] = client.locate(copyKey), pipelineCmds[key]
+		if pipelineCmds[key].Err() == nil {
+			delete(copies, key)
+		}
+	}
+	if len(copyShards) > 0 {
+		cc.recordBatch(copyShards, time.Since(start), func(key string) error {
+			return copyCmds[key].Err()
+		})
+	}
+	if len(copies) > 0 {
+		for key, pcmd := range cc.fillCopies(client, copies) {
+			pipelineCmds[key] = pcmd
+		}
+	}
+
+	if shards != nil {
+		// keys served by a copy are not calls of their own shard
+		for key := range copyShards {
+			if _, ok := copies[key]; !ok {
+				delete(shards, key)
+			}
+		}
+		cc.recordBatch(shards, time.Since(start), func(key string) error {
+			return pipelineCmds[key].Err()
+		})
 	}
 
-	res := make(map[string]*redis.StringCmd)
 	for key, pcmd := range pipelineCmds {
 		res[key] = pcmd
+		if nc != nil {
+			nc.fill(key, tokens[key], pcmd.Val(), pcmd.Err() == nil)
+		}
+
+		switch err := pcmd.Err(); err {
+		case nil:
+			atomic.AddUint64(&cc.stats.hits, 1)
+		case redis.Nil:
+			atomic.AddUint64(&cc.stats.misses, 1)
+		default:
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if failed == len(res) {
+		cc.count(opGets, "", start, firstErr)
+		return nil, firstErr
 	}
 
+	cc.count(opGets, "", start, nil)
 	return res, nil
 }
 
-// Sets set strings to cache
-func (cc *CacheClient) Sets(kvs map[string]interface{},expire int) (error) {
+// MultiGetResult is the result of MultiGet
+type MultiGetResult struct {
+	// Hits is the values of the keys found
+	Hits map[string]string
+	// Misses is the keys not in cache
+	Misses []string
+	// Errors is the keys failed to read, like keys of a down shard
+	Errors map[string]error
+}
+
+// MultiGet get strings from cache and tell hits, misses and failed keys
+// apart. It only fails when no key can be read.
+func (cc *CacheClient) MultiGet(keys []string) (*MultiGetResult, error) {
+	cmds, err := cc.Gets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &MultiGetResult{
+		Hits:   make(map[string]string),
+		Errors: make(map[string]error),
+	}
+	for key, cmd := range cmds {
+		val, err := cmd.Result()
+		switch err {
+		case nil:
+			res.Hits[key] = val
+		case redis.Nil:
+			res.Misses = append(res.Misses, key)
+		default:
+			res.Errors[key] = err
+		}
+	}
+	return res, nil
+}
+
+// Sets set strings to cache, expire is in seconds
+//
+// Deprecated: use SetsWithTTL
+func (cc *CacheClient) Sets(kvs map[string]interface{}, expire int) error {
+	return cc.SetsWithTTL(kvs, expireSeconds(expire))
+}
+
+// SetsWithTTL set strings to cache, the ttl of each key is changed by the
+// TTL policy like SetWithTTL
+func (cc *CacheClient) SetsWithTTL(kvs map[string]interface{}, ttl time.Duration) error {
+	ttls := make(map[string]time.Duration, len(kvs))
+	for key := range kvs {
+		ttls[key] = cc.ttlFor(key, ttl)
+	}
+	return cc.sets(kvs, ttls)
+}
+
+// sets write kvs with the final ttl of each key
+func (cc *CacheClient) sets(kvs map[string]interface{}, ttls map[string]time.Duration) error {
 	if len(kvs) <= 0 {
 		return errors.New("kvs is empty")
 	}
-	pipe := cc.ring.Pipeline()
-	pipelineCmds := make([]*redis.StatusCmd, 0, len(kvs))
-	for key,value  := range kvs {
-		pipelineCmds = append(pipelineCmds, pipe.Set(key,value,time.Duration(expire)))
+	client := cc.acquire()
+	defer client.release()
+
+	start := time.Now()
+	keys := make([]string, 0, len(kvs))
+	for key := range kvs {
+		keys = append(keys, key)
+	}
+	// keys of a shard whose breaker is open are not written
+	shards, rejected := cc.allowKeys(client, keys)
+	for _, key := range rejected {
+		cc.nearDel(key)
+	}
+
+	pipe := client.Pipeline()
+	pipelineCmds := make(map[string]*redis.StatusCmd, len(kvs))
+	for key, value := range kvs {
+		if _, ok := shards[key]; shards != nil && !ok {
+			continue
+		}
+		cc.recordKeys(key)
+		pipelineCmds[key] = pipe.Set(key, value, ttls[key])
 	}
 	_, err := pipe.Exec()
+	// copies are written after the keys, see fillCopies
+	copyPipe := client.Pipeline()
+	var copyCmds int
+	for key, pcmd := range pipelineCmds {
+		if pcmd.Err() != nil {
+			copyCmds += cc.updateCopies(copyPipe, client, key, nil, 0)
+		} else {
+			copyCmds += cc.updateCopies(copyPipe, client, key, kvs[key], ttls[key])
+		}
+	}
+	execCopies(copyPipe, copyCmds)
+	if shards != nil {
+		cc.recordBatch(shards, time.Since(start), func(key string) error {
+			return pipelineCmds[key].Err()
+		})
+	}
+	if err == nil && len(rejected) > 0 {
+		err = ErrShardUnavailable
+	}
+	cc.count(opSets, "", start, err)
+	changed := make([]string, 0, len(pipelineCmds))
+	for key, pcmd := range pipelineCmds {
+		if pcmd.Err() != nil {
+			cc.nearDel(key)
+		} else {
+			cc.nearSet(key, kvs[key], ttls[key])
+			changed = append(changed, key)
+		}
+	}
+	cc.publishInvalidation(changed...)
 	if err != nil {
 		return err
 	}
@@ -184,70 +561,163 @@ func (cc *CacheClient) Sets(kvs map[string]interface{},expire int) (error) {
 	return nil
 }
 
-// GetStrings get strings from cache
+// GetStrings get strings from cache, missed keys are not in the result.
+// Keys failed to read, like keys of a down shard, are reported in a
+// KeyErrors error with the strings read, use MultiGet to get them without
+// an error.
 func (cc *CacheClient) GetStrings(keys []string) (map[string]string, error) {
-	kvs, err := cc.Gets(keys)
-	if(err != nil){
-		return nil,err
-	}
-	rets := make(map[string]string)
-	for key,value := range kvs{
-		value_real,err_real := value.Result();
-		if (err_real != nil){
-			return rets,errors.New("error:" + err_real.Error() + "; key:" + key)
-		}
-		rets[key] = value_real;
+	res, err := cc.MultiGet(keys)
+	if err != nil {
+		return nil, err
 	}
 
-	return rets,nil
+	if len(res.Errors) > 0 {
+		return res.Hits, KeyErrors(res.Errors)
+	}
+	return res.Hits, nil
 }
 
-// SetStrings set strings to cache
+// SetStrings set strings to cache, expire is in seconds
+//
+// Deprecated: use SetStringsWithTTL
 func (cc *CacheClient) SetStrings(kvs map[string]string, expire int) error {
-	kvs_real := make(map[string]interface{})
-	for key,value := range kvs{
-		kvs_real[key] = value;
+	return cc.SetStringsWithTTL(kvs, expireSeconds(expire))
+}
+
+// SetStringsWithTTL set strings to cache
+func (cc *CacheClient) SetStringsWithTTL(kvs map[string]string, ttl time.Duration) error {
+	kvsReal := make(map[string]interface{})
+	for key, value := range kvs {
+		kvsReal[key] = value
 	}
-	err := cc.Sets(kvs_real, expire)
+	err := cc.SetsWithTTL(kvsReal, ttl)
 	return err
 }
 
-// GetObjects get objects from cache
-func (cc *CacheClient) GetObjects(keys []string,value_type interface{})(map[string]interface{},error) {
-	if len(keys) <= 0 {
-		return nil,errors.New("keys is empty")
+// GetObjects get objects from cache, missed and failed keys are not in the
+// result. Each value is decoded into its own new value of value_type's
+// type, so a pointer value_type gives pointers and a struct gives structs.
+func (cc *CacheClient) GetObjects(keys []string, value_type interface{}) (map[string]interface{}, error) {
+	t := reflect.TypeOf(value_type)
+	newValue := func() interface{} {
+		switch {
+		case t == nil:
+			return new(interface{})
+		case t.Kind() == reflect.Ptr:
+			return reflect.New(t.Elem()).Interface()
+		default:
+			return reflect.New(t).Interface()
+		}
+	}
+
+	kvs, _, err := cc.GetObjectsInto(keys, newValue)
+	if kvs == nil {
+		return nil, err
+	}
+	if t == nil || t.Kind() != reflect.Ptr {
+		for key, value := range kvs {
+			kvs[key] = reflect.ValueOf(value).Elem().Interface()
+		}
+	}
+	return kvs, err
+}
+
+// MissSet is the keys not found in cache
+type MissSet map[string]struct{}
+
+// Has report whether key is missed
+func (s MissSet) Has(key string) bool {
+	_, ok := s[key]
+	return ok
+}
+
+// KeyErrors is the errors of single keys in a batch
+type KeyErrors map[string]error
+
+func (e KeyErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for key, err := range e {
+		msgs = append(msgs, fmt.Sprintf("key=%q: %s", key, err))
+	}
+	sort.Strings(msgs)
+	return "cache: " + strings.Join(msgs, "; ")
+}
+
+// GetObjectsInto get objects from cache, each value is decoded into a new
+// value from newValue, which should return a pointer like &MyType{}. Keys
+// failed to read or decode are reported in a KeyErrors error, the other
+// keys are still returned. Keys cached as missing are neither returned nor
+// in the MissSet.
+func (cc *CacheClient) GetObjectsInto(keys []string, newValue func() interface{}) (map[string]interface{}, MissSet, error) {
+	res, err := cc.MultiGet(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kvs := make(map[string]interface{}, len(res.Hits))
+	misses := make(MissSet, len(res.Misses))
+	errs := make(KeyErrors)
+	for _, key := range res.Misses {
+		misses[key] = struct{}{}
 	}
-	kvs_real,err := cc.Gets(keys);
-	if (err != nil){
-		return nil,err
+	for key, err := range res.Errors {
+		errs[key] = err
 	}
-	kvs := make(map[string]interface{})
-	for key,value :=range kvs_real{
-		value_byte,err_real := value.Bytes()
-		if (err_real != nil){
-			return kvs,err_real
+	for key, value := range res.Hits {
+		if isTombstone([]byte(value)) {
+			cc.tombstoneHit()
+			continue
 		}
-		err_real = json.Unmarshal(value_byte,&value_type)
-		kvs[key] = value_type
-		if (err_real != nil){
-			log.Printf("cache: Unmarshal key=%q failed: %s", key, err_real)
-			return kvs,err_real
+		v := newValue()
+		if err := decodeObject([]byte(value), v, cc.config().Compression.MaxSize); err != nil {
+			log.Printf("cache: Unmarshal key=%q failed: %s", key, err)
+			errs[key] = err
+			continue
 		}
+		kvs[key] = v
+	}
+
+	if len(errs) > 0 {
+		return kvs, misses, errs
+	}
+	return kvs, misses, nil
+}
+
+// GetObjectsAs get objects from cache like GetObjectsInto, each value is
+// decoded into a new T
+func GetObjectsAs[T any](cc *CacheClient, keys []string) (map[string]*T, MissSet, error) {
+	kvs, misses, err := cc.GetObjectsInto(keys, func() interface{} { return new(T) })
+	if kvs == nil {
+		return nil, misses, err
+	}
+
+	res := make(map[string]*T, len(kvs))
+	for key, value := range kvs {
+		res[key] = value.(*T)
 	}
-	return kvs,nil
+	return res, misses, err
 }
 
-// SetObjects set objects to cache, object
+// SetObjects set objects to cache, expire is in seconds
+//
+// Deprecated: use SetObjectsWithTTL
 func (cc *CacheClient) SetObjects(kvs map[string]interface{}, expire int) error {
-	for key,value := range kvs{
-		value_real, err := json.Marshal(value)
+	return cc.SetObjectsWithTTL(kvs, expireSeconds(expire))
+}
+
+// SetObjectsWithTTL set objects to cache
+func (cc *CacheClient) SetObjectsWithTTL(kvs map[string]interface{}, ttl time.Duration) error {
+	codec := cc.codec()
+	kvsReal := make(map[string]interface{})
+	ttls := make(map[string]time.Duration, len(kvs))
+	for key, value := range kvs {
+		valueReal, err := cc.encode(key, codec, value)
 		if err != nil {
-			log.Printf("cache: Marshal key=%q failed: %s", key, err)
 			return err
 		}
-		kvs[key] = value_real
+		kvsReal[key], ttls[key] = cc.staleWrite(valueReal, cc.ttlFor(key, ttl), 0)
 	}
-	err := cc.Sets(kvs, expire)
+	err := cc.sets(kvsReal, ttls)
 	return err
 }
 
@@ -258,39 +728,47 @@ type Stats struct {
 	HitRatio  float64
 	Rt        float64
 	QPS       int
+
+	// CompressRatio is compressed size / original size of the values
+	// compressed so far, BytesSaved is the difference in bytes
+	CompressRatio float64 `json:",omitempty"`
+	BytesSaved    int64   `json:",omitempty"`
+
+	// L1 is the near cache, L2 is Redis, HitRatio counts both
+	L1Hits   uint64 `json:",omitempty"`
+	L1Misses uint64 `json:",omitempty"`
+	L2Hits   uint64 `json:",omitempty"`
+	L2Misses uint64 `json:",omitempty"`
+
+	// HotKeys is the top Config.HotKeys.TopN keys of the last window
+	HotKeys []HotKey `json:",omitempty"`
+
+	// Requests, Errors and Timeouts are the calls of all operations in
+	// Ops, Hits and Misses count both L1 and L2
+	Requests int64              `json:",omitempty"`
+	Errors   int64              `json:",omitempty"`
+	Timeouts int64              `json:",omitempty"`
+	Hits     uint64             `json:",omitempty"`
+	Misses   uint64             `json:",omitempty"`
+	Ops      map[string]OpStats `json:",omitempty"`
+	// Stale is the object reads served past their soft expiry
+	Stale uint64 `json:",omitempty"`
+	// EarlyRefreshes is the XFetch refreshes started before expiry
+	EarlyRefreshes uint64 `json:",omitempty"`
+	// TombstoneHits is the object reads of keys cached as missing, they are
+	// in Hits as well
+	TombstoneHits uint64 `json:",omitempty"`
+
+	// Latency is the percentiles of all operations, in µs
+	Latency Latency
+	// Shards is the stats of single key calls by shard
+	Shards map[string]ShardStats `json:",omitempty"`
 }
 
-// GetStats return stats info
+// GetStats return stats info since the last call of GetStats in JSON, use
+// Snapshot or NewStatsWindow to get the stats without sharing this window
 func (cc *CacheClient) GetStats() string {
-	st := &Stats{}
-
-	hits := atomic.LoadUint64(&cc.stats.hits)
-	misses := atomic.LoadUint64(&cc.stats.misses)
-	request := atomic.LoadInt64(&cc.stats.request)
-	elapse := atomic.LoadInt64(&cc.stats.elapse)
-	st.StartTime = atomic.LoadInt64(&cc.stats.timeStart)
-	st.EndTime = time.Now().UnixNano()
-	// ms
-	interval := (st.EndTime - st.StartTime) / 1e6
-
-	if hits == 0 && misses == 0 {
-		b, _ := json.Marshal(st)
-		return string(b[:])
-	}
-	if interval == 0 {
-		b, _ := json.Marshal(st)
-		return string(b[:])
-	}
-
-	st.HitRatio = float64(hits * 100.0 / (hits + misses))
-	st.QPS = int(request * 1000 / interval)
-	// ms
-	st.Rt = float64(elapse / request / 1e6)
-
-	atomic.AddInt64(&cc.stats.request, -request)
-	atomic.AddInt64(&cc.stats.elapse, -elapse)
-	atomic.AddInt64(&cc.stats.timeStart, -st.StartTime+time.Now().UnixNano())
-
+	st := cc.window.Next()
 	b, _ := json.Marshal(st)
 	return string(b[:])
 }
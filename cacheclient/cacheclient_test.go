@@ -21,7 +21,7 @@ func Test_NewCacheClient(t *testing.T) {
 		t.Error("NewCacheClient error:", err)
 	}
 
-	err = cc.ring.Ping().Err()
+	err = cc.client.Ping().Err()
 	if err != nil {
 		t.Error("ping server error:", err)
 	}
@@ -234,3 +234,111 @@ func Test_GetStats(t *testing.T) {
 		t.Error(st)
 	}
 }
+
+func Test_MultiGet(t *testing.T) {
+	InitPackage("redis.json")
+	cc, _ := NewCacheClient()
+
+	cc.SetStringWithTTL("key2", "v2", time.Minute)
+
+	res, err := cc.MultiGet([]string{"key2", "key111"})
+	if err != nil {
+		t.Fatal("Test_MultiGet", err)
+	}
+	if res.Hits["key2"] != "v2" || len(res.Misses) != 1 || res.Misses[0] != "key111" || len(res.Errors) != 0 {
+		t.Error("Test_MultiGet", res)
+	}
+
+	kvs, err := cc.GetStrings([]string{"key2", "key111"})
+	if err != nil || len(kvs) != 1 || kvs["key2"] != "v2" {
+		t.Error("Test_MultiGet GetStrings", kvs, err)
+	}
+}
+
+func Test_MultiGet_ServerDown(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, _ := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"))
+	defer cc.Close()
+
+	_, err := cc.MultiGet([]string{"key1", "key2"})
+	if err == nil {
+		t.Error("MultiGet should fail when no key can be read")
+	}
+}
+
+// GetStrings returns the strings read and the keys failed
+func Test_GetStrings_PartialFailure(t *testing.T) {
+	cc, srv := newTestClient(t)
+	cc.SetStringWithTTL("key1", "v1", time.Minute)
+	cc.SetStringWithTTL("key2", "v2", time.Minute)
+	srv.fail("key2")
+
+	kvs, err := cc.GetStrings([]string{"key1", "key2", "key3"})
+	errs, ok := err.(KeyErrors)
+	if !ok || len(errs) != 1 || errs["key2"] == nil {
+		t.Error("GetStrings should report the failed key", err)
+	}
+	if len(kvs) != 1 || kvs["key1"] != "v1" {
+		t.Error("GetStrings should return the strings read", kvs)
+	}
+
+	res, err := cc.MultiGet([]string{"key1", "key2", "key3"})
+	if err != nil || len(res.Hits) != 1 || len(res.Errors) != 1 || len(res.Misses) != 1 {
+		t.Error("MultiGet should not fail on a failed key", res, err)
+	}
+}
+
+func Test_GetObjectsInto(t *testing.T) {
+	InitPackage("redis.json")
+	cc, _ := NewCacheClient()
+
+	cc.SetObjectsWithTTL(map[string]interface{}{
+		"object1": &object{Str: "o1", Num: 1},
+		"object2": &object{Str: "o2", Num: 2},
+	}, time.Minute)
+	cc.SetStringWithTTL("object3", "not json", time.Minute)
+
+	kvs, misses, err := cc.GetObjectsInto([]string{"object1", "object2", "object3", "object4"},
+		func() interface{} { return &object{} })
+
+	errs, ok := err.(KeyErrors)
+	if !ok || len(errs) != 1 || errs["object3"] == nil {
+		t.Error("Test_GetObjectsInto decode error should be per key", err)
+	}
+	if !misses.Has("object4") || len(misses) != 1 {
+		t.Error("Test_GetObjectsInto misses", misses)
+	}
+	o1, ok1 := kvs["object1"].(*object)
+	o2, ok2 := kvs["object2"].(*object)
+	if len(kvs) != 2 || !ok1 || !ok2 {
+		t.Fatalf("Test_GetObjectsInto values %v", kvs)
+	}
+	if o1.Num != 1 || o2.Num != 2 {
+		t.Error("Test_GetObjectsInto values", o1, o2)
+	}
+
+	objs, _ := cc.GetObjects([]string{"object1", "object2"}, object{})
+	v1, ok1 := objs["object1"].(object)
+	v2, ok2 := objs["object2"].(object)
+	if !ok1 || !ok2 {
+		t.Fatalf("Test_GetObjectsInto GetObjects values %v", objs)
+	}
+	if v1.Str != "o1" || v2.Str != "o2" {
+		t.Error("Test_GetObjectsInto GetObjects values", v1, v2)
+	}
+
+	typed, _, _ := GetObjectsAs[object](cc, []string{"object1", "object2"})
+	if typed["object1"] == nil || typed["object1"].Str != "o1" || typed["object2"] == nil || typed["object2"].Num != 2 {
+		t.Error("Test_GetObjectsInto GetObjectsAs values", typed)
+	}
+}
+
+func Test_GetObjectsAs(t *testing.T) {
+	cc, _ := newTestClient(t)
+	cc.SetObjectWithTTL("object1", &object{Str: "o1", Num: 1}, time.Minute)
+
+	objs, misses, err := GetObjectsAs[object](cc, []string{"object1", "object2"})
+	if err != nil || len(objs) != 1 || objs["object1"] == nil || objs["object1"].Str != "o1" || !misses.Has("object2") {
+		t.Error("GetObjectsAs error", objs, misses, err)
+	}
+}
@@ -0,0 +1,207 @@
+package cacheclient
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec marshal objects stored by SetObject and friends
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs, the value written by a codec starts with its header byte
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+	ProtoCodec   Codec = protoCodec{}
+)
+
+// Header bytes of the built-in codecs. Values written before codecs were
+// added have no header and are JSON, which never starts with a byte below
+// 0x20 other than whitespace, so the header bytes are kept below it.
+const (
+	jsonCodecID    byte = 0x01
+	gobCodecID     byte = 0x02
+	msgpackCodecID byte = 0x03
+	protoCodecID   byte = 0x04
+
+	// user codecs use 0x10 - 0x1f
+	minUserCodecID byte = 0x10
+	maxUserCodecID byte = 0x1f
+)
+
+var (
+	codecMu     sync.RWMutex
+	codecsByID  = make(map[byte]Codec)
+	codecIDs    = make(map[string]byte)
+	codecByName = make(map[string]Codec)
+)
+
+func init() {
+	registerCodec(jsonCodecID, JSONCodec)
+	registerCodec(gobCodecID, GobCodec)
+	registerCodec(msgpackCodecID, MsgpackCodec)
+	registerCodec(protoCodecID, ProtoCodec)
+}
+
+// RegisterCodec add a user codec, id is the header byte written before its
+// values and must be in 0x10 - 0x1f. Every reader must register the same
+// codec with the same id.
+func RegisterCodec(id byte, c Codec) error {
+	if id < minUserCodecID || id > maxUserCodecID {
+		return fmt.Errorf("cache: codec id 0x%02x out of range 0x10 - 0x1f", id)
+	}
+
+	codecMu.RLock()
+	_, idUsed := codecsByID[id]
+	_, nameUsed := codecIDs[c.Name()]
+	codecMu.RUnlock()
+	if idUsed || nameUsed {
+		return fmt.Errorf("cache: codec %s or id 0x%02x already registered", c.Name(), id)
+	}
+
+	registerCodec(id, c)
+	return nil
+}
+
+func registerCodec(id byte, c Codec) {
+	codecMu.Lock()
+	codecsByID[id] = c
+	codecIDs[c.Name()] = id
+	codecByName[c.Name()] = c
+	codecMu.Unlock()
+}
+
+// codecNamed return the registered codec with the name, "" is JSON
+func codecNamed(name string) (Codec, bool) {
+	if name == "" {
+		return JSONCodec, true
+	}
+
+	codecMu.RLock()
+	c, ok := codecByName[name]
+	codecMu.RUnlock()
+	return c, ok
+}
+
+// encodeObject marshal v with c and put the codec header byte before it
+func encodeObject(c Codec, v interface{}) ([]byte, error) {
+	codecMu.RLock()
+	id, ok := codecIDs[c.Name()]
+	codecMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("cache: codec %s is not registered", c.Name())
+	}
+
+	b, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(b)+1)
+	data = append(data, id)
+	return append(data, b...), nil
+}
+
+// decodeObject unmarshal data with the codec that wrote it, data without a
+// codec header is JSON. The stale envelope is dropped and a compressed
+// object is decompressed up to maxSize bytes, see decompress.
+func decodeObject(data []byte, v interface{}, maxSize int) error {
+	data, _ = openStale(data)
+	data, err := decompress(data, maxSize)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		codecMu.RLock()
+		c, ok := codecsByID[data[0]]
+		codecMu.RUnlock()
+		if ok {
+			return c.Unmarshal(data[1:], v)
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// msgpackCodec is vmihailenco/msgpack, its values are read by the
+// MessagePack libraries of other languages. Numbers decoded into an
+// interface{} are int64, uint64 or float64.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.NewDecoder(bytes.NewReader(data)).UseDecodeInterfaceLoose(true).Decode(v)
+}
+
+// protoMarshaler and protoUnmarshaler are implemented by generated protobuf
+// messages (proto.Marshaler and proto.Unmarshaler), so the codec works
+// without importing a protobuf package
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(protoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("cache: proto codec can not marshal %T", v)
+	}
+	return m.Marshal()
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(protoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("cache: proto codec can not unmarshal into %T", v)
+	}
+	return m.Unmarshal(data)
+}
@@ -0,0 +1,143 @@
+package cacheclient
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecObject struct {
+	Str   string
+	Num   int
+	Neg   int64
+	Big   uint64
+	Rate  float64
+	Ok    bool
+	Tags  []string
+	Attrs map[string]int
+	Raw   []byte
+	When  time.Time
+	Next  *codecObject
+	Skip  string `msgpack:"-"`
+}
+
+// fakeProto has the Marshal/Unmarshal methods of generated protobuf messages
+type fakeProto struct {
+	Body string
+}
+
+func (m *fakeProto) Marshal() ([]byte, error) { return []byte(m.Body), nil }
+
+func (m *fakeProto) Unmarshal(b []byte) error {
+	m.Body = string(b)
+	return nil
+}
+
+func Test_Codecs(t *testing.T) {
+	in := codecObject{Str: "test", Num: 1, Neg: -100000, Big: 1 << 40, Rate: 0.5, Ok: true,
+		Tags: []string{"a", "b"}, Attrs: map[string]int{"x": 300}, Raw: []byte{0, 1},
+		When: time.Date(2018, 3, 16, 0, 0, 0, 0, time.UTC), Next: &codecObject{Str: "next"}}
+
+	for _, c := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		b, err := encodeObject(c, &in)
+		if err != nil {
+			t.Error(c.Name(), "encode error", err)
+			continue
+		}
+
+		var out codecObject
+		if err = decodeObject(b, &out, 0); err != nil {
+			t.Error(c.Name(), "decode error", err)
+			continue
+		}
+		// the msgpack timestamp has no location
+		out.When = out.When.UTC()
+		if !reflect.DeepEqual(in, out) {
+			t.Error(c.Name(), "roundtrip error", out)
+		}
+	}
+
+	b, err := encodeObject(ProtoCodec, &fakeProto{Body: "proto"})
+	var pm fakeProto
+	if err != nil || decodeObject(b, &pm, 0) != nil || pm.Body != "proto" {
+		t.Error("proto roundtrip error", err, pm)
+	}
+	if _, err = encodeObject(ProtoCodec, &in); err == nil {
+		t.Error("proto codec should reject non proto object")
+	}
+}
+
+func Test_decodeObject_Legacy(t *testing.T) {
+	b, _ := json.Marshal(&object{Str: "test", Num: 1})
+
+	var out object
+	if err := decodeObject(b, &out, 0); err != nil || out.Str != "test" || out.Num != 1 {
+		t.Error("legacy json decode error", err, out)
+	}
+}
+
+func Test_msgpackInterface(t *testing.T) {
+	b, err := MsgpackCodec.Marshal(map[string]interface{}{"n": -3, "s": "str", "l": []int{1, 2}})
+	if err != nil {
+		t.Fatal("msgpack marshal error", err)
+	}
+
+	var out interface{}
+	if err = MsgpackCodec.Unmarshal(b, &out); err != nil {
+		t.Fatal("msgpack unmarshal error", err)
+	}
+	m := out.(map[string]interface{})
+	if m["n"] != int64(-3) || m["s"] != "str" || len(m["l"].([]interface{})) != 2 {
+		t.Error("msgpack interface decode error", out)
+	}
+
+	var short interface{}
+	if err = MsgpackCodec.Unmarshal(b[:len(b)-1], &short); err == nil {
+		t.Error("msgpack should fail on truncated data")
+	}
+}
+
+// time is the timestamp extension type -1 of the MessagePack spec
+func Test_msgpackTime(t *testing.T) {
+	when := time.Date(2018, 3, 16, 0, 0, 0, 0, time.UTC)
+	b, err := MsgpackCodec.Marshal(when)
+	if err != nil {
+		t.Fatal("msgpack marshal error", err)
+	}
+	// fixext 4, type -1, then the seconds
+	if len(b) != 6 || b[0] != 0xd6 || int8(b[1]) != -1 {
+		t.Errorf("time should be a timestamp extension: % x", b)
+	}
+
+	var out time.Time
+	if err = MsgpackCodec.Unmarshal(b, &out); err != nil || !out.Equal(when) {
+		t.Error("msgpack time decode error", out, err)
+	}
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Name() string                          { return "upper" }
+func (upperCodec) Marshal(v interface{}) ([]byte, error) { return []byte(v.(string)), nil }
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func Test_RegisterCodec(t *testing.T) {
+	if err := RegisterCodec(0x05, upperCodec{}); err == nil {
+		t.Error("RegisterCodec should reject reserved id")
+	}
+	if err := RegisterCodec(0x1f, upperCodec{}); err != nil {
+		t.Fatal("RegisterCodec error", err)
+	}
+	if err := RegisterCodec(0x1e, upperCodec{}); err == nil {
+		t.Error("RegisterCodec should reject duplicate name")
+	}
+
+	c := Config{Codec: "upper"}
+	if _, ok := codecNamed(c.Codec); !ok {
+		t.Error("registered codec not found by name")
+	}
+}
@@ -0,0 +1,314 @@
+package cacheclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compress objects larger than Config.Compression.Threshold
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// LimitDecompressor is a Compressor that stops decompressing once the output
+// is larger than max bytes, the built-in compressors are. The output of other
+// compressors is checked after Decompress.
+type LimitDecompressor interface {
+	DecompressLimit(data []byte, max int) ([]byte, error)
+}
+
+// ErrDecompressLimit is returned for a compressed object larger than
+// Config.Compression.MaxSize once decompressed
+var ErrDecompressLimit = errors.New("cache: decompressed object too large")
+
+// defaultMaxDecompressed is the Compression.MaxSize used when it is 0
+const defaultMaxDecompressed = 64 << 20
+
+// Built-in compressors, more can be added by RegisterCompressor
+var (
+	GzipCompressor   Compressor = gzipCompressor{}
+	SnappyCompressor Compressor = snappyCompressor{}
+	ZstdCompressor   Compressor = zstdCompressor{}
+)
+
+// Only objects are compressed: they start with a header byte of the client,
+// so a compressed object is told apart by its own header byte, while the
+// strings and bytes of Set are stored and read as they are.
+
+// compressedID is the header byte of a compressed object, followed by the
+// compressor id and the compressed object with its codec header. Like the
+// codec ids it is below 0x20, and it is not JSON whitespace.
+const compressedID byte = 0x0b
+
+// ids of the built-in compressors
+const (
+	gzipCompressorID   byte = 0x01
+	snappyCompressorID byte = 0x02
+	zstdCompressorID   byte = 0x03
+)
+
+var (
+	compressorMu      sync.RWMutex
+	compressorsByID   = make(map[byte]Compressor)
+	compressorIDs     = make(map[string]byte)
+	compressorsByName = make(map[string]Compressor)
+)
+
+func init() {
+	registerCompressor(gzipCompressorID, GzipCompressor)
+	registerCompressor(snappyCompressorID, SnappyCompressor)
+	registerCompressor(zstdCompressorID, ZstdCompressor)
+}
+
+// RegisterCompressor add a compressor, id is written after the header byte
+// of its values and must be in 0x10 - 0xff. Every reader must register the
+// same compressor with the same id.
+func RegisterCompressor(id byte, c Compressor) error {
+	if id < 0x10 {
+		return fmt.Errorf("cache: compressor id 0x%02x is reserved", id)
+	}
+
+	compressorMu.RLock()
+	_, idUsed := compressorsByID[id]
+	_, nameUsed := compressorIDs[c.Name()]
+	compressorMu.RUnlock()
+	if idUsed || nameUsed {
+		return fmt.Errorf("cache: compressor %s or id 0x%02x already registered", c.Name(), id)
+	}
+
+	registerCompressor(id, c)
+	return nil
+}
+
+func registerCompressor(id byte, c Compressor) {
+	compressorMu.Lock()
+	compressorsByID[id] = c
+	compressorIDs[c.Name()] = id
+	compressorsByName[c.Name()] = c
+	compressorMu.Unlock()
+}
+
+// compressorNamed return the registered compressor, "" means no compression
+func compressorNamed(name string) (Compressor, bool) {
+	if name == "" {
+		return nil, true
+	}
+
+	compressorMu.RLock()
+	c, ok := compressorsByName[name]
+	compressorMu.RUnlock()
+	return c, ok
+}
+
+// encode encode v with codec and compress it as the config says, it is the
+// value of an object before the stale envelope
+func (cc *CacheClient) encode(key string, codec Codec, v interface{}) ([]byte, error) {
+	b, err := encodeObject(codec, v)
+	if err != nil {
+		log.Printf("cache: Marshal key=%q failed: %s", key, err)
+		return nil, err
+	}
+	return cc.compress(key, b), nil
+}
+
+// compress return data, an encoded object, compressed when it is larger
+// than the threshold and compressing makes it smaller
+func (cc *CacheClient) compress(key string, data []byte) []byte {
+	conf := cc.config().Compression
+	c, _ := compressorNamed(conf.Type)
+	if c == nil || len(data) <= conf.Threshold {
+		return data
+	}
+
+	compressorMu.RLock()
+	id := compressorIDs[c.Name()]
+	compressorMu.RUnlock()
+
+	b, err := c.Compress(data)
+	if err != nil {
+		log.Printf("cache: compress key=%q failed: %s", key, err)
+		return data
+	}
+	if len(b)+2 >= len(data) {
+		return data
+	}
+
+	out := make([]byte, 0, 2+len(b))
+	out = append(out, compressedID, id)
+	out = append(out, b...)
+
+	atomic.AddInt64(&cc.stats.compressIn, int64(len(data)))
+	atomic.AddInt64(&cc.stats.compressOut, int64(len(out)))
+	return out
+}
+
+// decompress return data, an object without its stale envelope, not
+// compressed. An object not compressed is returned as it is, one larger than
+// max bytes once decompressed fails, max 0 is defaultMaxDecompressed.
+func decompress(data []byte, max int) ([]byte, error) {
+	if len(data) < 2 || data[0] != compressedID {
+		return data, nil
+	}
+
+	compressorMu.RLock()
+	c, ok := compressorsByID[data[1]]
+	compressorMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown compressor id 0x%02x", data[1])
+	}
+	if max <= 0 {
+		max = defaultMaxDecompressed
+	}
+
+	if l, ok := c.(LimitDecompressor); ok {
+		return l.DecompressLimit(data[2:], max)
+	}
+	b, err := c.Decompress(data[2:])
+	if err == nil && len(b) > max {
+		return nil, errTooLarge(max)
+	}
+	return b, err
+}
+
+func errTooLarge(max int) error {
+	return fmt.Errorf("%w: over %d bytes", ErrDecompressLimit, max)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (gzipCompressor) DecompressLimit(data []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// one byte more than max tells a too large object apart
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > max {
+		return nil, errTooLarge(max)
+	}
+	return b, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string { return "snappy" }
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+func (snappyCompressor) DecompressLimit(data []byte, max int) ([]byte, error) {
+	// the decoded length is in the header, check it before allocating
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, errTooLarge(max)
+	}
+	return snappy.Decode(nil, data)
+}
+
+// zstd encoders and decoders are safe for concurrent EncodeAll and
+// DecodeAll, one of each is shared
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		// they only fail on invalid options
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// zstd decoders limited to a max size, one per max, as Compression.MaxSize
+// rarely changes
+var (
+	zstdLimitMu       sync.Mutex
+	zstdLimitDecoders = make(map[int]*zstd.Decoder)
+)
+
+func zstdLimitDecoder(max int) *zstd.Decoder {
+	zstdLimitMu.Lock()
+	defer zstdLimitMu.Unlock()
+	dec, ok := zstdLimitDecoders[max]
+	if !ok {
+		dec, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(max)))
+		zstdLimitDecoders[max] = dec
+	}
+	return dec
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string { return "zstd" }
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	enc, _ := zstdCodec()
+	return enc.EncodeAll(data, nil), nil
+}
+
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	_, dec := zstdCodec()
+	return dec.DecodeAll(data, nil)
+}
+
+func (zstdCompressor) DecompressLimit(data []byte, max int) ([]byte, error) {
+	b, err := zstdLimitDecoder(max).DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, errTooLarge(max)
+	}
+	if err == nil && len(b) > max {
+		return nil, errTooLarge(max)
+	}
+	return b, err
+}
@@ -0,0 +1,121 @@
+package cacheclient
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_compress(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithCompression("gzip", 64))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	small, _ := cc.encode("k", JSONCodec, "small value")
+	if small[0] != jsonCodecID {
+		t.Error("object under threshold should not be compressed", small)
+	}
+
+	big := strings.Repeat("test", 100)
+	v, err := cc.encode("k", JSONCodec, big)
+	if err != nil || v[0] != compressedID || v[1] != gzipCompressorID {
+		t.Fatal("object over threshold should be compressed", v[:2], err)
+	}
+
+	var out string
+	if err = decodeObject(v, &out, 0); err != nil || out != big {
+		t.Error("decodeObject should decompress", err)
+	}
+	if err = decodeObject(sealStale(v, time.Now().Add(time.Minute)), &out, 0); err != nil || out != big {
+		t.Error("decodeObject should decompress in the stale envelope", err)
+	}
+
+	if cc.stats.compressIn != int64(len(big)+3) || cc.stats.compressOut != int64(len(v)) {
+		t.Error("compress stats error", cc.stats.compressIn, cc.stats.compressOut)
+	}
+}
+
+func Test_Compressors(t *testing.T) {
+	data := []byte(strings.Repeat(`{"Str":"test","Num":1}`, 100))
+	for _, c := range []Compressor{GzipCompressor, SnappyCompressor, ZstdCompressor} {
+		b, err := c.Compress(data)
+		if err != nil || len(b) >= len(data) {
+			t.Error(c.Name(), "compress error", len(b), err)
+			continue
+		}
+		if out, err := c.Decompress(b); err != nil || string(out) != string(data) {
+			t.Error(c.Name(), "decompress error", err)
+		}
+		if _, ok := compressorNamed(c.Name()); !ok {
+			t.Error(c.Name(), "should be registered")
+		}
+	}
+}
+
+func Test_decompress_Limit(t *testing.T) {
+	data := []byte(strings.Repeat("a", 10000))
+	for _, c := range []Compressor{GzipCompressor, SnappyCompressor, ZstdCompressor} {
+		b, _ := c.Compress(data)
+		id := compressorIDs[c.Name()]
+		v := append([]byte{compressedID, id}, b...)
+
+		if _, err := decompress(v, 9999); !errors.Is(err, ErrDecompressLimit) {
+			t.Error(c.Name(), "object over the limit should fail", err)
+		}
+		if out, err := decompress(v, 10000); err != nil || len(out) != len(data) {
+			t.Error(c.Name(), "object at the limit should decompress", err)
+		}
+	}
+
+	cc, _ := newTestClient(t, WithCompression("snappy", 0), WithDecompressLimit(100))
+	if err := cc.SetObjectWithTTL("key1", string(data), 0); err != nil {
+		t.Fatal("SetObjectWithTTL error", err)
+	}
+	var out string
+	if err := cc.GetObject("key1", &out); !errors.Is(err, ErrDecompressLimit) {
+		t.Error("GetObject should fail over the limit", err)
+	}
+}
+
+// strings and bytes of Set are never taken as compressed
+func Test_compress_Raw(t *testing.T) {
+	cc, _ := newTestClient(t, WithCompression("zstd", 0))
+
+	for _, v := range []string{"\xffz\x01raw", "\x0b\x01raw", "\x0b"} {
+		if err := cc.SetWithTTL("key1", []byte(v), 0); err != nil {
+			t.Fatal("SetWithTTL error", err)
+		}
+		if got, err := cc.GetString("key1"); err != nil || got != v {
+			t.Errorf("raw value should be read as it is: %q %q %v", v, got, err)
+		}
+	}
+
+	big := strings.Repeat("test", 100)
+	if err := cc.SetObjectWithTTL("key2", big, 0); err != nil {
+		t.Fatal("SetObjectWithTTL error", err)
+	}
+	var out string
+	if err := cc.GetObject("key2", &out); err != nil || out != big {
+		t.Error("GetObject should decompress", err)
+	}
+}
+
+func Test_compress_Disabled(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, _ := NewCacheClientWithConfig(cfg)
+	defer cc.Close()
+
+	big := []byte(strings.Repeat("a", 10000))
+	if v := cc.compress("k", big); len(v) != len(big) {
+		t.Error("compression is not enabled")
+	}
+
+	cfg.Compression.Type = "lz4"
+	if _, err := NewCacheClientWithConfig(cfg); err == nil {
+		t.Error("unknown compressor should fail")
+	}
+}
@@ -2,64 +2,529 @@ package cacheclient
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-type config struct {
-	HashType           string
-	Addrs              []string
-	HeartbeatFrequency time.Duration
+// Config is the configuration of a CacheClient, usually loaded from
+// redis.json. Durations are strings like "500ms" or "30s", a bare number
+// is read as seconds for old config files.
+type Config struct {
+	// HashType select the backend: ring(default), cluster, sentinel or single
+	HashType string
+	// Addrs is "name:host:port" for ring, "host:port" for the others
+	Addrs []string
+	// MasterName is the sentinel master name, only for sentinel
+	MasterName string
+	// HeartbeatFrequency of ring shards checking
+	HeartbeatFrequency Duration
 	DB                 int
 	Password           string
 	MaxRetries         int
-	Stats              struct {
-		Interval time.Duration
-	}
-	ConnTimeout struct {
-		DialTimeout  time.Duration
-		ReadTimeout  time.Duration
-		WriteTimeout time.Duration
+	// Codec name used by SetObject: json(default), gob, msgpack, proto or
+	// a codec added by RegisterCodec
+	Codec       string
+	Stats       StatsConfig
+	ConnTimeout ConnTimeoutConfig
+	Pool        PoolConfig
+	Compression CompressionConfig
+	Loader      LoaderConfig
+	NearCache   NearCacheConfig
+	HotKeys     HotKeysConfig
+	Breaker     BreakerConfig
+	Stale       StaleConfig
+	TTL         TTLConfig
+	Negative    NegativeConfig
+}
+
+// CompressionConfig is the object compression part of Config
+type CompressionConfig struct {
+	// Type is the compressor name: gzip, snappy, zstd or one added by
+	// RegisterCompressor, empty means no compression
+	Type string
+	// Threshold is the size in bytes above which objects are compressed
+	Threshold int
+	// MaxSize is the largest size in bytes of a decompressed object, larger
+	// ones fail to decode with ErrDecompressLimit. 0 means 64MB.
+	MaxSize int
+}
+
+// StatsConfig is the stats part of Config
+type StatsConfig struct {
+	// Interval of the stats reports, 0 disables the reporting
+	Interval Duration
+	// Log write the reports to the log, it is the default sink when no
+	// other one is set
+	Log bool
+	// StatsD is the host:port of a StatsD server receiving the reports
+	StatsD string
+	// Prefix of the StatsD metric names, the default is "cacheclient"
+	Prefix string
+	// URL receive the reports as JSON POST requests
+	URL string
+}
+
+// ConnTimeoutConfig is the connection timeouts part of Config
+type ConnTimeoutConfig struct {
+	DialTimeout  Duration
+	ReadTimeout  Duration
+	WriteTimeout Duration
+}
+
+// PoolConfig is the connection pool part of Config, pool is per shard
+type PoolConfig struct {
+	PoolSize           int
+	PoolTimeout        Duration
+	IdleTimeout        Duration
+	IdleCheckFrequency Duration
+}
+
+// Duration is a time.Duration read from a string like "500ms" or "30s",
+// or from a number of seconds
+type Duration time.Duration
+
+// UnmarshalJSON accept both "30s" and the old 30
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
 	}
-	Pool struct {
-		PoolSize           int
-		PoolTimeout        time.Duration
-		IdleTimeout        time.Duration
-		IdleCheckFrequency time.Duration
+
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		pd, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(pd)
+	default:
+		return fmt.Errorf("cache: bad duration %s", b)
 	}
+	return nil
+}
+
+// MarshalJSON write d as a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// LoaderConfig is the cross process lock and early refresh of GetOrLoad
+type LoaderConfig struct {
+	// LockTTL of the lock key, 0 disables the lock
+	LockTTL Duration
+	// LockWait is how long other processes wait for the lock holder, the
+	// default is LockTTL
+	LockWait Duration
+	// PollInterval of the waiting processes, the default is 50ms
+	PollInterval Duration
+	// Beta of the XFetch early refresh, 0 disables it. WithEarlyRefresh
+	// sets it per call.
+	Beta float64
+}
+
+// NearCacheConfig is the in-process L1 cache in front of Redis
+type NearCacheConfig struct {
+	// Size is the max number of keys, 0 disables the near cache
+	Size int
+	// MaxTTL is the longest time a key is kept
+	MaxTTL Duration
+	// Channel is the Pub/Sub channel of invalidation messages, with it
+	// set a Set or Del drops the key from the near caches of all clients
+	Channel string
+}
+
+// HotKeysConfig is the hot key detection part of Config
+type HotKeysConfig struct {
+	// Size is the number of keys counted in a window, 0 disables the
+	// detection
+	Size int
+	// Window is the length of a counting window, the default is 10s
+	Window Duration
+	// SampleRate is the share of accesses counted, 0 means all of them
+	SampleRate float64
+	// TopN is the number of hot keys in GetStats, the default is 10
+	TopN int
+
+	// Replicas is the number of copies of a hot key kept on other shards
+	// of a ring or cluster, 0 disables the replication
+	Replicas int
+	// ReplicaQPS is the QPS above which a detected key is replicated, 0
+	// means only the keys in Keys or marked by MarkHotKeys
+	ReplicaQPS float64
+	// ReplicaTTL is the longest time a copy is kept, the default is Window
+	ReplicaTTL Duration
+	// Keys are always replicated
+	Keys []string
+}
+
+// BreakerConfig is the circuit breaker of each shard. A breaker opens when
+// the failed or slow calls of a window reach their rate, then calls to the
+// shard fail with ErrShardUnavailable for OpenTime. After that a few trial
+// calls go through and close it if they all succeed.
+type BreakerConfig struct {
+	// Window is the length of a counting window, 0 disables the breakers
+	Window Duration
+	// MinCalls in a window before the breaker may open, the default is 20
+	MinCalls int
+	// ErrorRate is the share of failed calls opening the breaker, the
+	// default is 0.5
+	ErrorRate float64
+	// SlowCall is the latency above which a call is slow, SlowRate is the
+	// share of slow calls opening the breaker, 0 means slow calls do not
+	SlowCall Duration
+	SlowRate float64
+	// OpenTime is how long calls are failed, the default is 5s
+	OpenTime Duration
+	// HalfOpenCalls is the number of trial calls, the default is 3
+	HalfOpenCalls int
+}
+
+// StaleConfig is the serve-stale mode of objects, a value written with a
+// ttl is kept in Redis for ttl + TTL and served stale after ttl
+type StaleConfig struct {
+	// TTL is how long a value is served stale, 0 disables the mode
+	TTL Duration
+}
+
+// TTLConfig is the TTL policy of the writes. A write without a ttl gets
+// the default of the key, every ttl is moved by the jitter so keys set
+// together do not expire together.
+type TTLConfig struct {
+	// Jitter is the share a ttl is moved by at random, 0.1 is ±10%
+	Jitter float64
+	// Default is the ttl of keys matching no prefix, 0 means no expire
+	Default Duration
+	// Prefixes map key prefixes to their policy, the longest prefix of a
+	// key wins
+	Prefixes map[string]TTLPolicy
+}
+
+// TTLPolicy is the ttl of the keys of a prefix, 0 is not set. A key
+// written without a ttl gets Default, else TTLConfig.Default, else Max.
+type TTLPolicy struct {
+	Default Duration
+	Min     Duration
+	Max     Duration
+}
+
+// NegativeConfig is the caching of keys known to be missing
+type NegativeConfig struct {
+	// TTL of the tombstones, the default is 30s
+	TTL Duration
 }
 
-var conf config
+// conf is the package config filled by InitPackage
+var conf Config
 
 const defaultConfPath = "/etc/putong/redis/redis.json"
 
-// parse conf to struct Config
-func parseConf(path string) error {
-	var cPath string
+// LoadConfig read the config file at path (defaultConfPath if empty) and
+// validate it
+func LoadConfig(path string) (Config, error) {
+	var c Config
 
 	if path == "" {
-		cPath = defaultConfPath
-	} else {
-		cPath = path
+		path = defaultConfPath
 	}
-	data, err := ioutil.ReadFile(cPath)
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return err
+		return c, err
 	}
 
-	err = json.Unmarshal(data, &conf)
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("cache: parse config %s: %s", path, err)
+	}
+
+	if err = c.Validate(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// parse conf to struct Config
+func parseConf(path string) error {
+	c, err := LoadConfig(path)
 	if err != nil {
 		return err
 	}
+
+	conf = c
+	return nil
+}
+
+// FieldError is a problem of one config field
+type FieldError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s=%v: %s", e.Field, e.Value, e.Reason)
+}
+
+// ConfigError is all the problems found by Config.Validate
+type ConfigError []*FieldError
+
+func (e ConfigError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "cache: invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Validate check the whole config, it returns a ConfigError listing every
+// bad field, or nil
+func (c *Config) Validate() error {
+	var errs ConfigError
+	add := func(field string, value interface{}, reason string) {
+		errs = append(errs, &FieldError{Field: field, Value: value, Reason: reason})
+	}
+
+	switch c.HashType {
+	case "", HashTypeRing, HashTypeCluster, HashTypeSingle:
+	case HashTypeSentinel:
+		if c.MasterName == "" {
+			add("MasterName", c.MasterName, "required by sentinel")
+		}
+	default:
+		add("HashType", c.HashType, "unknown, want ring, cluster, sentinel or single")
+	}
+
+	if len(c.Addrs) == 0 {
+		add("Addrs", c.Addrs, "empty")
+	}
+	names := make(map[string]bool)
+	for i, node := range c.Addrs {
+		field := fmt.Sprintf("Addrs[%d]", i)
+		if c.HashType == "" || c.HashType == HashTypeRing {
+			name, addr, err := splitNode(node)
+			if err != nil {
+				add(field, node, err.Error())
+				continue
+			}
+			if names[name] {
+				add(field, node, "duplicate shard name "+name)
+			}
+			names[name] = true
+			node = addr
+		} else {
+			node = hostPorts([]string{node})[0]
+		}
+		if err := checkHostPort(node); err != nil {
+			add(field, node, err.Error())
+		}
+	}
+
+	if _, ok := codecNamed(c.Codec); !ok {
+		add("Codec", c.Codec, "unknown codec")
+	}
+
+	if _, ok := compressorNamed(c.Compression.Type); !ok {
+		add("Compression.Type", c.Compression.Type, "unknown compressor")
+	}
+	if c.Compression.Threshold < 0 {
+		add("Compression.Threshold", c.Compression.Threshold, "negative")
+	}
+	if c.Compression.MaxSize < 0 {
+		add("Compression.MaxSize", c.Compression.MaxSize, "negative")
+	}
+
+	if c.Loader.LockTTL < 0 {
+		add("Loader.LockTTL", c.Loader.LockTTL, "negative")
+	}
+	if c.Loader.LockWait < 0 {
+		add("Loader.LockWait", c.Loader.LockWait, "negative")
+	}
+	if c.Loader.PollInterval < 0 {
+		add("Loader.PollInterval", c.Loader.PollInterval, "negative")
+	}
+	if c.Loader.Beta < 0 {
+		add("Loader.Beta", c.Loader.Beta, "negative")
+	}
+
+	if c.NearCache.Size < 0 {
+		add("NearCache.Size", c.NearCache.Size, "negative")
+	}
+	if c.NearCache.Size > 0 && c.NearCache.MaxTTL <= 0 {
+		add("NearCache.MaxTTL", c.NearCache.MaxTTL, "must be positive with NearCache.Size")
+	}
+
+	if c.HotKeys.Size < 0 {
+		add("HotKeys.Size", c.HotKeys.Size, "negative")
+	}
+	if c.HotKeys.Window < 0 {
+		add("HotKeys.Window", c.HotKeys.Window, "negative")
+	}
+	if c.HotKeys.SampleRate < 0 || c.HotKeys.SampleRate > 1 {
+		add("HotKeys.SampleRate", c.HotKeys.SampleRate, "must be in [0, 1]")
+	}
+	if c.HotKeys.TopN < 0 {
+		add("HotKeys.TopN", c.HotKeys.TopN, "negative")
+	}
+	if c.HotKeys.Replicas < 0 {
+		add("HotKeys.Replicas", c.HotKeys.Replicas, "negative")
+	}
+	if c.HotKeys.ReplicaQPS < 0 {
+		add("HotKeys.ReplicaQPS", c.HotKeys.ReplicaQPS, "negative")
+	}
+	if c.HotKeys.ReplicaTTL < 0 {
+		add("HotKeys.ReplicaTTL", c.HotKeys.ReplicaTTL, "negative")
+	}
+
+	if c.Breaker.Window < 0 {
+		add("Breaker.Window", c.Breaker.Window, "negative")
+	}
+	if c.Breaker.MinCalls < 0 {
+		add("Breaker.MinCalls", c.Breaker.MinCalls, "negative")
+	}
+	if c.Breaker.ErrorRate < 0 || c.Breaker.ErrorRate > 1 {
+		add("Breaker.ErrorRate", c.Breaker.ErrorRate, "must be in [0, 1]")
+	}
+	if c.Breaker.SlowCall < 0 {
+		add("Breaker.SlowCall", c.Breaker.SlowCall, "negative")
+	}
+	if c.Breaker.SlowRate < 0 || c.Breaker.SlowRate > 1 {
+		add("Breaker.SlowRate", c.Breaker.SlowRate, "must be in [0, 1]")
+	}
+	if c.Breaker.SlowRate > 0 && c.Breaker.SlowCall <= 0 {
+		add("Breaker.SlowCall", c.Breaker.SlowCall, "must be positive with Breaker.SlowRate")
+	}
+	if c.Breaker.OpenTime < 0 {
+		add("Breaker.OpenTime", c.Breaker.OpenTime, "negative")
+	}
+	if c.Breaker.HalfOpenCalls < 0 {
+		add("Breaker.HalfOpenCalls", c.Breaker.HalfOpenCalls, "negative")
+	}
+
+	if c.Stale.TTL < 0 {
+		add("Stale.TTL", c.Stale.TTL, "negative")
+	}
+
+	if c.TTL.Jitter < 0 || c.TTL.Jitter >= 1 {
+		add("TTL.Jitter", c.TTL.Jitter, "must be in [0, 1)")
+	}
+	if c.TTL.Default < 0 {
+		add("TTL.Default", c.TTL.Default, "negative")
+	}
+	prefixes := make([]string, 0, len(c.TTL.Prefixes))
+	for prefix := range c.TTL.Prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		p := c.TTL.Prefixes[prefix]
+		field := fmt.Sprintf("TTL.Prefixes[%q]", prefix)
+		switch {
+		case prefix == "":
+			add(field, p, "empty prefix, use TTL.Default")
+		case p.Default < 0 || p.Min < 0 || p.Max < 0:
+			add(field, p, "negative")
+		case p.Max > 0 && p.Min > p.Max:
+			add(field, p, "Min above Max")
+		}
+	}
+
+	if c.Negative.TTL < 0 {
+		add("Negative.TTL", c.Negative.TTL, "negative")
+	}
+
+	if c.DB < 0 {
+		add("DB", c.DB, "negative")
+	}
+	if c.MaxRetries < 0 {
+		add("MaxRetries", c.MaxRetries, "negative")
+	}
+	if c.HeartbeatFrequency < 0 {
+		add("HeartbeatFrequency", c.HeartbeatFrequency, "negative")
+	}
+	if c.Stats.Interval < 0 {
+		add("Stats.Interval", c.Stats.Interval, "negative")
+	}
+	if c.Stats.StatsD != "" {
+		if err := checkHostPort(c.Stats.StatsD); err != nil {
+			add("Stats.StatsD", c.Stats.StatsD, err.Error())
+		}
+	}
+	if c.Stats.URL != "" {
+		if u, err := url.Parse(c.Stats.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("Stats.URL", c.Stats.URL, "not an absolute URL")
+		}
+	}
+
+	if c.ConnTimeout.DialTimeout <= 0 {
+		add("ConnTimeout.DialTimeout", c.ConnTimeout.DialTimeout, "must be positive")
+	}
+	if c.ConnTimeout.ReadTimeout <= 0 {
+		add("ConnTimeout.ReadTimeout", c.ConnTimeout.ReadTimeout, "must be positive")
+	}
+	if c.ConnTimeout.WriteTimeout <= 0 {
+		add("ConnTimeout.WriteTimeout", c.ConnTimeout.WriteTimeout, "must be positive")
+	}
+
+	if c.Pool.PoolSize < 0 {
+		add("Pool.PoolSize", c.Pool.PoolSize, "negative")
+	}
+	if c.Pool.PoolTimeout < 0 {
+		add("Pool.PoolTimeout", c.Pool.PoolTimeout, "negative")
+	}
+	if c.Pool.IdleTimeout < 0 {
+		add("Pool.IdleTimeout", c.Pool.IdleTimeout, "negative")
+	}
+	if c.Pool.IdleCheckFrequency < 0 {
+		add("Pool.IdleCheckFrequency", c.Pool.IdleCheckFrequency, "negative")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// splitNode split a ring "name:host:port" entry
+func splitNode(node string) (name, addr string, err error) {
+	sl := strings.SplitN(node, ":", 2)
+	if len(sl) != 2 || sl[0] == "" {
+		return "", "", errors.New("malformed, want name:host:port")
+	}
+	return sl[0], sl[1], nil
+}
+
+func checkHostPort(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return errors.New("malformed, want host:port")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return errors.New("bad port " + port)
+	}
 	return nil
 }
 
-func parseStringsToMap(nodes []string, addr map[string]string) {
+func parseStringsToMap(nodes []string, addr map[string]string) error {
 	for k := range nodes {
-		sl := strings.SplitN(nodes[k], ":", 2)
-		addr[sl[0]] = sl[1]
+		name, a, err := splitNode(nodes[k])
+		if err != nil {
+			return fmt.Errorf("cache: Addrs entry %q %s", nodes[k], err)
+		}
+		addr[name] = a
 	}
+	return nil
 }
 
 func initConf(confPath string) error {
@@ -1,8 +1,10 @@
 package cacheclient
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 )
 
 var debug = false
@@ -21,7 +23,7 @@ func Test_parseConfig(t *testing.T) {
 		t.Error("parse config PoolSize error")
 	}
 
-	if conf.ConnTimeout.DialTimeout != 30 {
+	if conf.ConnTimeout.DialTimeout != Duration(30*time.Second) {
 		t.Error("parse config DialTimeout error")
 	}
 }
@@ -62,3 +64,95 @@ func Test_parseStringsToMap_2(t *testing.T) {
 		t.Error("parse is error", addrs["server2"])
 	}
 }
+
+func Test_parseStringsToMap_Malformed(t *testing.T) {
+	addrs := make(map[string]string)
+	err := parseStringsToMap([]string{"192.168.4.41"}, addrs)
+	if err == nil {
+		t.Error("malformed node should fail", addrs)
+	}
+}
+
+func Test_LoadConfig_NoFile(t *testing.T) {
+	_, err := LoadConfig("no-such-redis.json")
+	if err == nil {
+		t.Error("LoadConfig should fail on missing file")
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	c, err := LoadConfig("redis.json")
+	if err != nil {
+		t.Fatal("redis.json should be valid", err)
+	}
+
+	c.Addrs = []string{"server1:127.0.0.1:6379", "server1:127.0.0.1:6380", "server3", "server4:127.0.0.1:port"}
+	c.Pool.PoolSize = -1
+	c.ConnTimeout.ReadTimeout = 0
+
+	err = c.Validate()
+	errs, ok := err.(ConfigError)
+	if !ok {
+		t.Fatal("Validate should return ConfigError", err)
+	}
+
+	if debug == true {
+		fmt.Println("Test_Validate:", err)
+	}
+
+	fields := make(map[string]bool)
+	for _, fe := range errs {
+		fields[fe.Field] = true
+	}
+	for _, f := range []string{"Addrs[1]", "Addrs[2]", "Addrs[3]", "Pool.PoolSize", "ConnTimeout.ReadTimeout"} {
+		if !fields[f] {
+			t.Error("Validate should report", f, err)
+		}
+	}
+	if len(errs) != 5 {
+		t.Error("Validate reported unexpected errors", err)
+	}
+
+	c, _ = LoadConfig("redis.json")
+	c.HashType = "consistent"
+	if err = c.Validate(); err == nil {
+		t.Error("Validate should report unknown HashType")
+	}
+
+	c, _ = LoadConfig("redis.json")
+	c.HotKeys.SampleRate = 1.5
+	if err = c.Validate(); err == nil {
+		t.Error("Validate should report HotKeys.SampleRate above 1")
+	}
+
+	c, _ = LoadConfig("redis.json")
+	c.Breaker.Window = Duration(time.Minute)
+	c.Breaker.SlowRate = 0.5
+	if err = c.Validate(); err == nil {
+		t.Error("Validate should report Breaker.SlowRate without Breaker.SlowCall")
+	}
+
+	c, _ = LoadConfig("redis.json")
+	c.TTL.Prefixes = map[string]TTLPolicy{"user:": {Min: Duration(time.Hour), Max: Duration(time.Minute)}}
+	if err = c.Validate(); err == nil {
+		t.Error("Validate should report TTL policy Min above Max")
+	}
+}
+
+func Test_Duration(t *testing.T) {
+	var c ConnTimeoutConfig
+	err := json.Unmarshal([]byte(`{"DialTimeout": 30, "ReadTimeout": "500ms", "WriteTimeout": 1.5}`), &c)
+	if err != nil {
+		t.Fatal("unmarshal durations error", err)
+	}
+
+	if c.DialTimeout != Duration(30*time.Second) || c.ReadTimeout != Duration(500*time.Millisecond) ||
+		c.WriteTimeout != Duration(1500*time.Millisecond) {
+		t.Error("unmarshal durations error", c)
+	}
+
+	err = json.Unmarshal([]byte(`{"DialTimeout": "30"}`), &c)
+	if err == nil {
+		t.Error("duration string without unit should fail")
+	}
+}
@@ -0,0 +1,161 @@
+package cacheclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// The vendored go-redis does not watch a context, a call that has started
+// waits on pool checkout, dial and read until the timeouts of the config.
+// Reads have no side effects, so the read Ctx variants run the call in
+// background and return ctx.Err() as soon as ctx is done, the result of the
+// call is dropped. Writes are never abandoned: they check ctx before
+// calling Redis and a started write returns its own result, so a write
+// failed with ctx.Err() has not been sent. ctx also stops the waits of the
+// client itself, like GetOrLoad waiting for a loader.
+
+// withContext call fn unless ctx is done
+func withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// readContext run the read fn and stop waiting for it when ctx is done, fn
+// keeps running in background until the timeouts of the config and its
+// result is dropped
+func readContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	type result struct {
+		val T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		val, err := fn()
+		done <- result{val, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.val, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// GetCtx get string from cache, it returns ctx.Err() when ctx is done first
+func (cc *CacheClient) GetCtx(ctx context.Context, key string) *redis.StringCmd {
+	cmd, err := readContext(ctx, func() (*redis.StringCmd, error) {
+		return cc.Get(key), nil
+	})
+	if err != nil {
+		return redis.NewStringResult("", err)
+	}
+	return cmd
+}
+
+// SetCtx set string to cache, it returns ctx.Err() when ctx is done before
+// the call
+func (cc *CacheClient) SetCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return withContext(ctx, func() error {
+		return cc.SetWithTTL(key, value, ttl)
+	})
+}
+
+// GetStringCtx get string from cache
+func (cc *CacheClient) GetStringCtx(ctx context.Context, key string) (string, error) {
+	return cc.GetCtx(ctx, key).Result()
+}
+
+// SetStringCtx set string to cache
+func (cc *CacheClient) SetStringCtx(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return cc.SetCtx(ctx, key, value, ttl)
+}
+
+// GetObjectCtx get object from cache like GetObject
+func (cc *CacheClient) GetObjectCtx(ctx context.Context, key string, object interface{}) error {
+	b, err := cc.GetCtx(ctx, key).Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = cc.decodeCached(key, b, object)
+	return err
+}
+
+// SetObjectCtx set object to cache
+func (cc *CacheClient) SetObjectCtx(ctx context.Context, key string, object interface{}, ttl time.Duration) error {
+	return withContext(ctx, func() error {
+		return cc.SetObjectWithTTL(key, object, ttl)
+	})
+}
+
+// DelCtx del by key
+func (cc *CacheClient) DelCtx(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := withContext(ctx, func() error {
+		var err error
+		n, err = cc.Del(key)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// GetsCtx get strings from cache
+func (cc *CacheClient) GetsCtx(ctx context.Context, keys []string) (map[string]*redis.StringCmd, error) {
+	res, err := readContext(ctx, func() (map[string]*redis.StringCmd, error) {
+		return cc.Gets(keys)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetsCtx set strings to cache
+func (cc *CacheClient) SetsCtx(ctx context.Context, kvs map[string]interface{}, ttl time.Duration) error {
+	return withContext(ctx, func() error {
+		return cc.SetsWithTTL(kvs, ttl)
+	})
+}
+
+// GetStringsCtx get strings from cache
+func (cc *CacheClient) GetStringsCtx(ctx context.Context, keys []string) (map[string]string, error) {
+	return readContext(ctx, func() (map[string]string, error) {
+		return cc.GetStrings(keys)
+	})
+}
+
+// SetStringsCtx set strings to cache
+func (cc *CacheClient) SetStringsCtx(ctx context.Context, kvs map[string]string, ttl time.Duration) error {
+	return withContext(ctx, func() error {
+		return cc.SetStringsWithTTL(kvs, ttl)
+	})
+}
+
+// GetObjectsCtx get objects from cache
+func (cc *CacheClient) GetObjectsCtx(ctx context.Context, keys []string, valueType interface{}) (map[string]interface{}, error) {
+	return readContext(ctx, func() (map[string]interface{}, error) {
+		return cc.GetObjects(keys, valueType)
+	})
+}
+
+// SetObjectsCtx set objects to cache
+func (cc *CacheClient) SetObjectsCtx(ctx context.Context, kvs map[string]interface{}, ttl time.Duration) error {
+	return withContext(ctx, func() error {
+		return cc.SetObjectsWithTTL(kvs, ttl)
+	})
+}
@@ -0,0 +1,113 @@
+package cacheclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_withContext(t *testing.T) {
+	errFn := errors.New("fn error")
+	err := withContext(context.Background(), func() error {
+		return errFn
+	})
+	if err != errFn {
+		t.Error("withContext should return fn error", err)
+	}
+
+	// a started call is not abandoned when ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = withContext(ctx, func() error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Error("withContext should return the result of a started call", err)
+	}
+
+	called := false
+	err = withContext(ctx, func() error {
+		called = true
+		return nil
+	})
+	if err != context.DeadlineExceeded || called {
+		t.Error("withContext should not call fn when ctx is done", err)
+	}
+}
+
+// a write failed with ctx.Err() is not sent
+func Test_SetCtx_Canceled(t *testing.T) {
+	cc, _ := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cc.SetCtx(ctx, "key1", "v1", time.Minute); err != context.Canceled {
+		t.Error("SetCtx should return ctx.Err()", err)
+	}
+	if _, err := cc.DelCtx(ctx, "key1"); err != context.Canceled {
+		t.Error("DelCtx should return ctx.Err()", err)
+	}
+	if _, err := cc.GetString("key1"); err != redis.Nil {
+		t.Error("key should not be written", err)
+	}
+
+	if err := cc.SetCtx(context.Background(), "key1", "v1", time.Minute); err != nil {
+		t.Fatal("SetCtx error", err)
+	}
+	if v, err := cc.GetStringCtx(context.Background(), "key1"); err != nil || v != "v1" {
+		t.Error("GetStringCtx error", v, err)
+	}
+}
+
+func Test_GetCtx_Canceled(t *testing.T) {
+	InitPackage("redis.json")
+	cc, _ := NewCacheClient()
+	defer cc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := cc.GetStringCtx(ctx, "key1")
+	if err != context.Canceled {
+		t.Error("GetStringCtx should return ctx.Err()", err)
+	}
+}
+
+// reads stop at the ctx deadline, not at ReadTimeout, on a server that
+// accepts and never replies
+func Test_GetCtx_Deadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen error", err)
+	}
+	defer ln.Close()
+
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs(ln.Addr().String()),
+		WithMaxRetries(0), WithTimeouts(time.Second, 2*time.Second, 2*time.Second))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cc.GetStringCtx(ctx, "key1"); err != context.DeadlineExceeded {
+		t.Error("GetStringCtx should return ctx.Err()", err)
+	}
+	if _, err := cc.GetsCtx(ctx, []string{"key1", "key2"}); err != context.DeadlineExceeded {
+		t.Error("GetsCtx should return ctx.Err()", err)
+	}
+	if _, err := cc.GetStringsCtx(ctx, []string{"key1"}); err != context.DeadlineExceeded {
+		t.Error("GetStringsCtx should return ctx.Err()", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Error("reads should stop at the ctx deadline", d)
+	}
+}
@@ -0,0 +1,195 @@
+package cacheclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serve PING, GET, SET, SETNX, DEL and PTTL from memory until
+// closed
+type fakeRedis struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+	data  map[string]string
+	// expire is the deadline of the keys with a ttl
+	expire map[string]time.Time
+	// failing is the keys whose GET returns an error
+	failing map[string]bool
+	// after, if set, runs after each command with the data locked
+	after func(args []string)
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen error", err)
+	}
+	s := &fakeRedis{ln: ln, data: make(map[string]string), expire: make(map[string]time.Time),
+		failing: make(map[string]bool)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		// *<n> then $<len> and the value of each argument
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(line[1 : len(line)-2])
+		args := make([]string, n)
+		for i := range args {
+			if line, err = r.ReadString('\n'); err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(line[1 : len(line)-2])
+			b := make([]byte, size+2)
+			if _, err = io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = string(b[:size])
+		}
+		conn.Write([]byte(s.do(args)))
+	}
+}
+
+func (s *fakeRedis) do(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, deadline := range s.expire {
+		if time.Now().After(deadline) {
+			delete(s.data, key)
+			delete(s.expire, key)
+		}
+	}
+
+	if s.after != nil {
+		defer s.after(args)
+	}
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		return "+PONG\r\n"
+	case "get":
+		if s.failing[args[1]] {
+			return "-ERR failing key\r\n"
+		}
+		v, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "set":
+		nx := strings.ToLower(args[len(args)-1]) == "nx"
+		if nx {
+			args = args[:len(args)-1]
+			if _, ok := s.data[args[1]]; ok {
+				return "$-1\r\n"
+			}
+		}
+		s.data[args[1]] = args[2]
+		delete(s.expire, args[1])
+		if len(args) == 5 {
+			n, _ := strconv.Atoi(args[4])
+			unit := time.Second
+			if strings.ToLower(args[3]) == "px" {
+				unit = time.Millisecond
+			}
+			s.expire[args[1]] = time.Now().Add(time.Duration(n) * unit)
+		}
+		return "+OK\r\n"
+	case "setnx":
+		if _, ok := s.data[args[1]]; ok {
+			return ":0\r\n"
+		}
+		s.data[args[1]] = args[2]
+		return ":1\r\n"
+	case "del":
+		_, ok := s.data[args[1]]
+		delete(s.data, args[1])
+		delete(s.expire, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "pttl":
+		if _, ok := s.data[args[1]]; !ok {
+			return ":-2\r\n"
+		}
+		deadline, ok := s.expire[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(deadline)/time.Millisecond)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// fail make GET of keys return an error
+func (s *fakeRedis) fail(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.failing[key] = true
+	}
+}
+
+// ttl return the ttl of key, 0 if it has none
+func (s *fakeRedis) ttl(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if deadline, ok := s.expire[key]; ok {
+		return time.Until(deadline)
+	}
+	return 0
+}
+
+func (s *fakeRedis) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// newTestClient return a client of a single fakeRedis with opts, both are
+// closed at the end of the test
+func newTestClient(t *testing.T, opts ...Option) (*CacheClient, *fakeRedis) {
+	srv := newFakeRedis(t)
+	t.Cleanup(srv.Close)
+
+	cfg, _ := LoadConfig("redis.json")
+	opts = append([]Option{WithHashType(HashTypeSingle), WithAddrs(srv.Addr())}, opts...)
+	cc, err := NewCacheClientWithConfig(cfg, opts...)
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc, srv
+}
@@ -0,0 +1,186 @@
+package cacheclient
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// defaultHeartbeatFrequency is the go-redis ring default, used when
+// Config.HeartbeatFrequency is not set
+const defaultHeartbeatFrequency = 500 * time.Millisecond
+
+// states of ShardHealth
+const (
+	ShardUp   = "up"
+	ShardDown = "down"
+)
+
+// ShardHealth is a shard at its last heartbeat. A ring shard is down when
+// the ring stops using it and its keys are remapped to the other shards.
+type ShardHealth struct {
+	Name  string
+	Addr  string `json:",omitempty"`
+	State string
+	// Since is when the shard got into State
+	Since     time.Time
+	LastCheck time.Time
+	// LastError is the last failed heartbeat, kept after the shard is up
+	LastError     string `json:",omitempty"`
+	LastErrorTime time.Time
+	Pool          *redis.PoolStats `json:",omitempty"`
+}
+
+// health is the heartbeat of the shards of a client, it is started by the
+// first use of the shard states
+type health struct {
+	start sync.Once
+	wg    sync.WaitGroup
+	// check serializes the heartbeats so each change is seen once
+	check sync.Mutex
+
+	mu     sync.Mutex
+	states []shardState
+	shards []ShardHealth
+	hooks  []func(name string, up bool)
+}
+
+// OnShardStateChange add a hook called with the name of a shard going up or
+// down and start the heartbeat. Hooks are called in order from the
+// heartbeat goroutine.
+func (cc *CacheClient) OnShardStateChange(fn func(name string, up bool)) {
+	cc.health.mu.Lock()
+	cc.health.hooks = append(cc.health.hooks, fn)
+	cc.health.mu.Unlock()
+	cc.startHealth()
+}
+
+// Health return the shards at the last heartbeat sorted by name, the
+// shards are checked now and the heartbeat started if there was none yet
+func (cc *CacheClient) Health() []ShardHealth {
+	cc.startHealth()
+	cc.health.mu.Lock()
+	shards := cc.health.shards
+	cc.health.mu.Unlock()
+	if shards == nil {
+		cc.heartbeat()
+		cc.health.mu.Lock()
+		shards = cc.health.shards
+		cc.health.mu.Unlock()
+	}
+	return append([]ShardHealth(nil), shards...)
+}
+
+// lastShardStates return the shards at the last heartbeat
+func (cc *CacheClient) lastShardStates() []shardState {
+	cc.startHealth()
+	cc.health.mu.Lock()
+	states := cc.health.states
+	cc.health.mu.Unlock()
+	if states == nil {
+		cc.heartbeat()
+		cc.health.mu.Lock()
+		states = cc.health.states
+		cc.health.mu.Unlock()
+	}
+	return states
+}
+
+// startHealth start the heartbeat loop once, unless the client is closed
+func (cc *CacheClient) startHealth() {
+	cc.health.start.Do(func() {
+		cc.health.mu.Lock()
+		defer cc.health.mu.Unlock()
+		select {
+		case <-cc.done:
+			return
+		default:
+		}
+		cc.health.wg.Add(1)
+		go cc.heartbeats()
+	})
+}
+
+// heartbeats check the shards every Config.HeartbeatFrequency until Close
+func (cc *CacheClient) heartbeats() {
+	defer cc.health.wg.Done()
+
+	for {
+		interval := time.Duration(cc.config().HeartbeatFrequency)
+		if interval <= 0 {
+			interval = defaultHeartbeatFrequency
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-cc.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		cc.heartbeat()
+	}
+}
+
+// heartbeat check the shards, record the results and call the hooks for the
+// shards that changed state. Shards added by Reload are not a change.
+func (cc *CacheClient) heartbeat() {
+	cc.health.check.Lock()
+	defer cc.health.check.Unlock()
+
+	states := cc.shardStates()
+	now := time.Now()
+
+	cc.health.mu.Lock()
+	prev := make(map[string]ShardHealth, len(cc.health.shards))
+	for _, h := range cc.health.shards {
+		prev[h.Name] = h
+	}
+
+	var changed []ShardHealth
+	shards := make([]ShardHealth, 0, len(states))
+	for _, s := range states {
+		h := ShardHealth{Name: s.Name, Addr: s.Addr, State: ShardDown, Since: now, LastCheck: now, Pool: s.Pool}
+		if s.Up {
+			h.State = ShardUp
+		}
+		p, seen := prev[s.Name]
+		if seen {
+			h.LastError, h.LastErrorTime = p.LastError, p.LastErrorTime
+			if p.State == h.State {
+				h.Since = p.Since
+			} else {
+				changed = append(changed, h)
+			}
+		}
+		if s.Err != nil {
+			h.LastError, h.LastErrorTime = s.Err.Error(), now
+		}
+		shards = append(shards, h)
+	}
+	cc.health.states = states
+	cc.health.shards = shards
+	hooks := cc.health.hooks
+	cc.health.mu.Unlock()
+
+	for _, h := range changed {
+		if h.State == ShardUp {
+			log.Printf("cache: shard %s (%s) is up", h.Name, h.Addr)
+		} else {
+			log.Printf("cache: shard %s (%s) is down: %s", h.Name, h.Addr, h.LastError)
+		}
+		for _, fn := range hooks {
+			fn(h.Name, h.State == ShardUp)
+		}
+	}
+}
+
+// stopHealth wait for the heartbeat loop after done is closed
+func (cc *CacheClient) stopHealth() {
+	// a startHealth holding mu sees done closed after it
+	cc.health.mu.Lock()
+	cc.health.mu.Unlock()
+	cc.health.wg.Wait()
+}
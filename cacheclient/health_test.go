@@ -0,0 +1,109 @@
+package cacheclient
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Health(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	shards := cc.Health()
+	if len(shards) != 1 {
+		t.Fatal("Health should return one shard", shards)
+	}
+	h := shards[0]
+	if h.Name != "127.0.0.1:1" || h.State != ShardDown || h.LastError == "" || h.LastCheck.IsZero() {
+		t.Error("Health error", h)
+	}
+}
+
+func Test_OnShardStateChange(t *testing.T) {
+	srv := newFakeRedis(t)
+	defer srv.Close()
+
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeRing),
+		WithAddrs("server1:"+srv.Addr()), WithHeartbeatFrequency(20*time.Millisecond))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	changes := make(chan bool, 4)
+	cc.OnShardStateChange(func(name string, up bool) {
+		if name == "server1" {
+			changes <- up
+		}
+	})
+
+	if h := cc.Health(); len(h) != 1 || h[0].State != ShardUp || h[0].LastError != "" {
+		t.Fatal("shard should be up", h)
+	}
+
+	srv.Close()
+	select {
+	case up := <-changes:
+		if up {
+			t.Error("shard should go down")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no state change after the server is down")
+	}
+	if h := cc.Health(); h[0].State != ShardDown || h[0].LastError == "" || h[0].Pool != nil {
+		t.Error("shard should be down", h)
+	}
+}
+
+func Test_Health_Lazy(t *testing.T) {
+	cc, _ := newTestClient(t, WithHeartbeatFrequency(10*time.Millisecond))
+
+	time.Sleep(30 * time.Millisecond)
+	cc.health.mu.Lock()
+	shards := cc.health.shards
+	cc.health.mu.Unlock()
+	if shards != nil {
+		t.Fatal("heartbeat should not run before the shard states are used", shards)
+	}
+
+	first := cc.Health()
+	if len(first) != 1 || first[0].State != ShardUp {
+		t.Fatal("shard should be up", first)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if h := cc.Health(); !h[0].LastCheck.After(first[0].LastCheck) {
+		t.Error("heartbeat should run after Health", h)
+	}
+}
+
+func Test_probe_Timeout(t *testing.T) {
+	// accept and never reply
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen error", err)
+	}
+	defer ln.Close()
+
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeRing), WithAddrs("server1:"+ln.Addr().String()))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	client := cc.acquire()
+	defer client.release()
+	start := time.Now()
+	if err := client.probe(ln.Addr().String()).Ping().Err(); err == nil {
+		t.Error("ping of a silent server should fail")
+	}
+	if d := time.Since(start); d > 3*probeTimeout {
+		t.Error("probe should time out within probeTimeout", d)
+	}
+}
@@ -0,0 +1,125 @@
+package cacheclient
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// histogram count latencies in µs in log-linear buckets: values below 16
+// have a bucket each, above that every power of two is split into 16
+// buckets, so a percentile is off by at most 1/16. Buckets are updated
+// with atomics only.
+type histogram struct {
+	counts histCounts
+}
+
+const (
+	histSubBits = 4
+	histSub     = 1 << histSubBits
+	// values are capped at 2^30µs, about 18 minutes
+	histMaxBits = 30
+	histBuckets = (histMaxBits - histSubBits + 1) * histSub
+)
+
+// histCounts is a copy of the buckets of a histogram
+type histCounts [histBuckets]uint64
+
+// Latency is the percentiles of call latencies in µs
+type Latency struct {
+	P50  int64
+	P90  int64
+	P99  int64
+	P999 int64
+}
+
+func (h *histogram) add(d time.Duration) {
+	atomic.AddUint64(&h.counts[histBucket(d)], 1)
+}
+
+// load return a copy of the buckets
+func (h *histogram) load() *histCounts {
+	c := new(histCounts)
+	for i := range h.counts {
+		c[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return c
+}
+
+func histBucket(d time.Duration) int {
+	us := uint64(0)
+	if d > 0 {
+		us = uint64(d / time.Microsecond)
+	}
+	if us >= 1<<histMaxBits {
+		return histBuckets - 1
+	}
+	if us < histSub {
+		return int(us)
+	}
+	shift := bits.Len64(us) - histSubBits - 1
+	return (shift+1)*histSub + int(us>>uint(shift)) - histSub
+}
+
+// histUpper return the largest value in µs of bucket i
+func histUpper(i int) int64 {
+	if i < histSub {
+		return int64(i)
+	}
+	shift := uint(i/histSub - 1)
+	lower := int64(i%histSub+histSub) << shift
+	return lower + 1<<shift - 1
+}
+
+// sub return the counts added since prev, c and prev may be nil
+func (c *histCounts) sub(prev *histCounts) *histCounts {
+	if c == nil {
+		return nil
+	}
+	d := *c
+	if prev != nil {
+		for i := range d {
+			d[i] -= prev[i]
+		}
+	}
+	return &d
+}
+
+func (c *histCounts) merge(o *histCounts) {
+	for i := range c {
+		c[i] += o[i]
+	}
+}
+
+// latency return the percentiles of c
+func (c *histCounts) latency() Latency {
+	var total uint64
+	for _, n := range c {
+		total += n
+	}
+	if total == 0 {
+		return Latency{}
+	}
+
+	return Latency{
+		P50:  c.percentile(total, 0.5),
+		P90:  c.percentile(total, 0.9),
+		P99:  c.percentile(total, 0.99),
+		P999: c.percentile(total, 0.999),
+	}
+}
+
+func (c *histCounts) percentile(total uint64, q float64) int64 {
+	rank := uint64(q*float64(total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var n uint64
+	for i, count := range c {
+		n += count
+		if n >= rank {
+			return histUpper(i)
+		}
+	}
+	return histUpper(histBuckets - 1)
+}
@@ -0,0 +1,48 @@
+package cacheclient
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_histBucket(t *testing.T) {
+	for _, us := range []int64{0, 1, 15, 16, 17, 31, 32, 33, 100, 1000, 123456, 1 << 29} {
+		i := histBucket(time.Duration(us) * time.Microsecond)
+		if histUpper(i) < us || (i > 0 && histUpper(i-1) >= us) {
+			t.Error("bucket of", us, "is", i, histUpper(i))
+		}
+		// 1/16 precision
+		if float64(histUpper(i)-us) > float64(us)/16 {
+			t.Error("bucket of", us, "too wide", histUpper(i))
+		}
+	}
+	if histBucket(time.Hour) != histBuckets-1 {
+		t.Error("large values should be capped")
+	}
+}
+
+func Test_histogram(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 1000; i++ {
+		h.add(time.Duration(i) * time.Microsecond)
+	}
+
+	l := h.load().latency()
+	within := func(got, want int64) bool {
+		return got >= want && got <= want+want/16
+	}
+	if !within(l.P50, 500) || !within(l.P90, 900) || !within(l.P99, 990) || !within(l.P999, 999) {
+		t.Error("percentiles error", l)
+	}
+
+	prev := h.load()
+	for i := 0; i < 10; i++ {
+		h.add(5 * time.Millisecond)
+	}
+	if l = h.load().sub(prev).latency(); !within(l.P50, 5000) {
+		t.Error("percentiles of window error", l)
+	}
+	if l = new(histCounts).latency(); l != (Latency{}) {
+		t.Error("empty histogram should have no percentiles", l)
+	}
+}
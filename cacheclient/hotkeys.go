@@ -0,0 +1,260 @@
+package cacheclient
+
+import (
+	"container/heap"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHotKeysWindow = 10 * time.Second
+	defaultHotKeysTopN   = 10
+)
+
+// HotKey is a frequently accessed key, QPS is approximate and Shard is the
+// shard serving the key
+type HotKey struct {
+	Key   string
+	QPS   float64
+	Shard string
+}
+
+// hotKeys count the keys accessed in fixed windows with a Space-Saving
+// sketch, the result of the last full window is kept for HotKeys
+type hotKeys struct {
+	mu     sync.Mutex
+	window time.Duration
+	rate   float64
+	counts *spaceSaving
+	start  time.Time
+
+	last       []hotCount
+	lastWindow time.Duration
+
+	// hot is the keys above replicaQPS in the last window
+	replicaQPS float64
+	hot        map[string]bool
+}
+
+type hotCount struct {
+	key   string
+	count uint64
+}
+
+// newHotKeys return nil when c disables the detection
+func newHotKeys(c HotKeysConfig) *hotKeys {
+	if c.Size <= 0 {
+		return nil
+	}
+
+	window := time.Duration(c.Window)
+	if window == 0 {
+		window = defaultHotKeysWindow
+	}
+	rate := c.SampleRate
+	if rate == 0 {
+		rate = 1
+	}
+	return &hotKeys{
+		window: window,
+		rate:   rate,
+		counts: newSpaceSaving(c.Size),
+		start:  time.Now(),
+
+		replicaQPS: c.ReplicaQPS,
+	}
+}
+
+func (hk *hotKeys) record(key string) {
+	if hk.rate < 1 && rand.Float64() >= hk.rate {
+		return
+	}
+
+	hk.mu.Lock()
+	hk.rotate(time.Now())
+	hk.counts.add(key)
+	hk.mu.Unlock()
+}
+
+// rotate start a new window when the current one is over
+func (hk *hotKeys) rotate(now time.Time) {
+	elapsed := now.Sub(hk.start)
+	if elapsed < hk.window {
+		return
+	}
+
+	hk.last = hk.counts.top()
+	hk.lastWindow = elapsed
+	hk.counts.reset()
+	hk.start = now
+
+	hk.hot = nil
+	if hk.replicaQPS <= 0 {
+		return
+	}
+	for _, c := range hk.last {
+		if float64(c.count)/hk.rate/elapsed.Seconds() < hk.replicaQPS {
+			break
+		}
+		if hk.hot == nil {
+			hk.hot = make(map[string]bool)
+		}
+		hk.hot[c.key] = true
+	}
+}
+
+// isHot report whether key was above replicaQPS in the last window
+func (hk *hotKeys) isHot(key string) bool {
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+	return hk.hot[key]
+}
+
+// top return the n most accessed keys of the last window, or of the
+// current one before the first window is over
+func (hk *hotKeys) top(n int) []HotKey {
+	hk.mu.Lock()
+	now := time.Now()
+	hk.rotate(now)
+	counts, window := hk.last, hk.lastWindow
+	if counts == nil {
+		counts, window = hk.counts.top(), now.Sub(hk.start)
+	}
+	hk.mu.Unlock()
+
+	if n > len(counts) {
+		n = len(counts)
+	}
+	if n <= 0 || window <= 0 {
+		return nil
+	}
+
+	keys := make([]HotKey, n)
+	for i, c := range counts[:n] {
+		keys[i] = HotKey{Key: c.key, QPS: float64(c.count) / hk.rate / window.Seconds()}
+	}
+	return keys
+}
+
+// spaceSaving is the Space-Saving heavy hitters sketch: at most size keys
+// are counted, a new key replaces the least counted one and inherits its
+// count, so counts of hot keys are overestimated a little but never lost
+type spaceSaving struct {
+	size  int
+	items ssHeap
+	index map[string]*ssItem
+}
+
+type ssItem struct {
+	key   string
+	count uint64
+	i     int
+}
+
+func newSpaceSaving(size int) *spaceSaving {
+	return &spaceSaving{size: size, index: make(map[string]*ssItem, size)}
+}
+
+func (s *spaceSaving) add(key string) {
+	if it, ok := s.index[key]; ok {
+		it.count++
+		heap.Fix(&s.items, it.i)
+		return
+	}
+
+	if len(s.items) < s.size {
+		it := &ssItem{key: key, count: 1}
+		heap.Push(&s.items, it)
+		s.index[key] = it
+		return
+	}
+
+	it := s.items[0]
+	delete(s.index, it.key)
+	it.key = key
+	it.count++
+	s.index[key] = it
+	heap.Fix(&s.items, 0)
+}
+
+// top return all counted keys, the most counted first
+func (s *spaceSaving) top() []hotCount {
+	counts := make([]hotCount, len(s.items))
+	for i, it := range s.items {
+		counts[i] = hotCount{key: it.key, count: it.count}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].key < counts[j].key
+	})
+	return counts
+}
+
+func (s *spaceSaving) reset() {
+	s.items = nil
+	s.index = make(map[string]*ssItem, s.size)
+}
+
+// ssHeap is a min heap of counts
+type ssHeap []*ssItem
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].i = i
+	h[j].i = j
+}
+
+func (h *ssHeap) Push(x interface{}) {
+	it := x.(*ssItem)
+	it.i = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// hotKeyDetector return the hot key detector of the client, nil when
+// disabled
+func (cc *CacheClient) hotKeyDetector() *hotKeys {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.hot
+}
+
+// recordKeys count keys sent to Redis
+func (cc *CacheClient) recordKeys(keys ...string) {
+	hk := cc.hotKeyDetector()
+	if hk == nil {
+		return
+	}
+	for _, key := range keys {
+		hk.record(key)
+	}
+}
+
+// HotKeys return the n most accessed keys of the last window with their
+// shards, nil when Config.HotKeys is not enabled
+func (cc *CacheClient) HotKeys(n int) []HotKey {
+	hk := cc.hotKeyDetector()
+	if hk == nil {
+		return nil
+	}
+
+	keys := hk.top(n)
+	client := cc.acquire()
+	defer client.release()
+	for i := range keys {
+		keys[i].Shard = client.locate(keys[i].Key)
+	}
+	return keys
+}
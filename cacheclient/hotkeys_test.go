@@ -0,0 +1,81 @@
+package cacheclient
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_spaceSaving(t *testing.T) {
+	s := newSpaceSaving(10)
+	for i := 0; i < 100; i++ {
+		s.add("hot")
+		if i%2 == 0 {
+			s.add("warm")
+		}
+		// cold keys replace each other in the last counter
+		s.add("cold" + strconv.Itoa(i))
+	}
+
+	top := s.top()
+	if len(top) != 10 {
+		t.Fatal("sketch should keep size keys", len(top))
+	}
+	// counts are never underestimated
+	if top[0].key != "hot" || top[0].count < 100 {
+		t.Error("hot key should be first", top[0])
+	}
+	if top[1].key != "warm" || top[1].count < 50 {
+		t.Error("warm key should be second", top[1])
+	}
+
+	s.reset()
+	if len(s.top()) != 0 {
+		t.Error("reset sketch should be empty")
+	}
+}
+
+func Test_hotKeys(t *testing.T) {
+	if newHotKeys(HotKeysConfig{}) != nil {
+		t.Error("hot key detection should be disabled by default")
+	}
+
+	hk := newHotKeys(HotKeysConfig{Size: 10, Window: Duration(50 * time.Millisecond)})
+	for i := 0; i < 10; i++ {
+		hk.record("key1")
+	}
+	hk.record("key2")
+
+	// before the first window is over the current one is reported
+	top := hk.top(1)
+	if len(top) != 1 || top[0].Key != "key1" || top[0].QPS <= 0 {
+		t.Error("top of current window error", top)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	hk.record("key2")
+	top = hk.top(5)
+	if len(top) != 2 || top[0].Key != "key1" {
+		t.Error("top of last window error", top)
+	}
+}
+
+// server is down, keys sent to Redis are still counted
+func Test_HotKeys_Client(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithHotKeys(10, time.Minute, 0))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	cc.Get("key1")
+	cc.Get("key1")
+	cc.Del("key2")
+
+	keys := cc.HotKeys(1)
+	if len(keys) != 1 || keys[0].Key != "key1" || keys[0].Shard != "127.0.0.1:1" {
+		t.Error("HotKeys error", keys)
+	}
+}
@@ -0,0 +1,202 @@
+package cacheclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Near cache entries of other processes are dropped by invalidation
+// messages published on Config.NearCache.Channel. A message is the JSON of
+// invalidationMessage, so keys may hold any byte.
+
+const (
+	invalidationReceiveTimeout = 5 * time.Second
+	invalidationRetryInterval  = time.Second
+	// resubscribe after this many errors in a row, the channel may have
+	// moved to another ring shard
+	invalidationMaxErrors = 3
+)
+
+var errClosed = errors.New("cache: client is closed")
+
+// invalidationMessage is the payload of an invalidation message
+type invalidationMessage struct {
+	// Sender is the id of the client publishing the message
+	Sender string
+	Keys   []string
+}
+
+// invalidation is the subscription of a client
+type invalidation struct {
+	id string
+
+	start sync.Once
+
+	mu sync.Mutex
+	ps *redis.PubSub
+}
+
+// publishInvalidation tell other clients that keys are changed
+func (cc *CacheClient) publishInvalidation(keys ...string) {
+	channel := cc.config().NearCache.Channel
+	if channel == "" || len(keys) == 0 {
+		return
+	}
+
+	client := cc.acquire()
+	defer client.release()
+
+	msg, _ := json.Marshal(invalidationMessage{Sender: cc.invalidation.id, Keys: keys})
+	if err := client.Publish(channel, string(msg)).Err(); err != nil {
+		log.Printf("cache: publish invalidation of %d keys failed: %s", len(keys), err)
+	}
+}
+
+// startInvalidation start the receiving loop once the near cache and the
+// channel are both enabled
+func (cc *CacheClient) startInvalidation() {
+	c := cc.config().NearCache
+	if c.Size > 0 && c.Channel != "" {
+		cc.invalidation.start.Do(func() {
+			go cc.subscribeInvalidation()
+		})
+	}
+}
+
+// subscribeInvalidation receive invalidation messages until Close, it
+// resubscribes when the subscription is broken and flushes the near cache
+// whenever messages may have been lost
+func (cc *CacheClient) subscribeInvalidation() {
+	for {
+		select {
+		case <-cc.done:
+			return
+		default:
+		}
+
+		channel := cc.config().NearCache.Channel
+		nc := cc.nearCache()
+		if channel == "" || nc == nil {
+			time.Sleep(invalidationRetryInterval)
+			continue
+		}
+
+		ps, err := cc.subscribe(channel)
+		if err != nil {
+			log.Printf("cache: subscribe invalidation channel %s failed: %s", channel, err)
+			time.Sleep(invalidationRetryInterval)
+			continue
+		}
+
+		cc.receiveInvalidation(ps, nc)
+		ps.Close()
+		nc.flush()
+	}
+}
+
+// subscribe return a subscription of channel, Ring.Subscribe panics when
+// all shards are down
+func (cc *CacheClient) subscribe(channel string) (ps *redis.PubSub, err error) {
+	client := cc.acquire()
+	defer client.release()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	ps = client.Subscribe(channel)
+
+	cc.invalidation.mu.Lock()
+	defer cc.invalidation.mu.Unlock()
+	select {
+	case <-cc.done:
+		ps.Close()
+		return nil, errClosed
+	default:
+	}
+	cc.invalidation.ps = ps
+	return ps, nil
+}
+
+// subscribed report whether ps is still the current subscription
+func (cc *CacheClient) subscribed(ps *redis.PubSub) bool {
+	cc.invalidation.mu.Lock()
+	defer cc.invalidation.mu.Unlock()
+	return cc.invalidation.ps == ps
+}
+
+// resubscribe close the current subscription, the receiving loop will
+// subscribe again on the current backend
+func (cc *CacheClient) resubscribe() {
+	cc.invalidation.mu.Lock()
+	if cc.invalidation.ps != nil {
+		cc.invalidation.ps.Close()
+		cc.invalidation.ps = nil
+	}
+	cc.invalidation.mu.Unlock()
+}
+
+func (cc *CacheClient) receiveInvalidation(ps *redis.PubSub, nc *nearCache) {
+	var subscribed bool
+	var errNum int
+	for {
+		msgi, err := ps.ReceiveTimeout(invalidationReceiveTimeout)
+		if err != nil {
+			if !cc.subscribed(ps) {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				ps.Ping()
+				continue
+			}
+
+			nc.flush()
+			errNum++
+			if errNum >= invalidationMaxErrors {
+				log.Printf("cache: invalidation subscription broken, resubscribe: %s", err)
+				return
+			}
+			time.Sleep(invalidationRetryInterval)
+			continue
+		}
+		errNum = 0
+
+		switch msg := msgi.(type) {
+		case *redis.Subscription:
+			// go-redis resubscribes after a reconnect, messages sent
+			// while it was disconnected are lost
+			if subscribed {
+				nc.flush()
+			}
+			subscribed = true
+		case *redis.Message:
+			cc.handleInvalidation(msg.Payload, nc)
+		}
+	}
+}
+
+// handleInvalidation drop the keys of payload from nc, messages sent by this
+// client are skipped. A payload that can not be decoded flushes nc.
+func (cc *CacheClient) handleInvalidation(payload string, nc *nearCache) {
+	var msg invalidationMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		log.Printf("cache: bad invalidation message, flush the near cache: %s", err)
+		nc.flush()
+		return
+	}
+	if msg.Sender == cc.invalidation.id {
+		return
+	}
+	for _, key := range msg.Keys {
+		nc.del(key)
+	}
+}
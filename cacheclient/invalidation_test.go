@@ -0,0 +1,73 @@
+package cacheclient
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_handleInvalidation(t *testing.T) {
+	cc := &CacheClient{}
+	cc.invalidation.id = "me"
+	nc := newNearCache(NearCacheConfig{Size: 10, MaxTTL: Duration(time.Minute)})
+
+	nc.set("key1", "v1", 0)
+	nc.set("key\n2", "v2", 0)
+	nc.set("key3", "v3", 0)
+
+	payload := func(sender string, keys ...string) string {
+		b, _ := json.Marshal(invalidationMessage{Sender: sender, Keys: keys})
+		return string(b)
+	}
+
+	// messages of this client are skipped
+	cc.handleInvalidation(payload("me", "key1"), nc)
+	if _, ok := nc.get("key1"); !ok {
+		t.Error("own invalidation should be skipped")
+	}
+
+	cc.handleInvalidation(payload("other", "key1", "key\n2"), nc)
+	if _, ok := nc.get("key1"); ok {
+		t.Error("key1 should be invalidated")
+	}
+	if _, ok := nc.get("key\n2"); ok {
+		t.Error("key with a newline should be invalidated")
+	}
+	if _, ok := nc.get("key3"); !ok {
+		t.Error("key3 should be kept")
+	}
+
+	cc.handleInvalidation("other\nkey3", nc)
+	if nc.len() != 0 {
+		t.Error("bad message should flush the near cache", nc.len())
+	}
+}
+
+// server is down, the receiving loop keeps retrying until Close
+func Test_invalidation_ServerDown(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithNearCache(100, time.Minute), WithInvalidationChannel("cache.invalidation"))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+
+	cc.nearCache().set("key1", "v1", 0)
+	if _, err := cc.Del("key1"); err == nil {
+		t.Error("Del should fail with server down")
+	}
+	if _, ok := cc.nearCache().get("key1"); ok {
+		t.Error("key1 should be dropped from the near cache")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cc.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Close should not block on the subscription")
+	}
+}
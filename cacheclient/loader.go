@@ -0,0 +1,308 @@
+package cacheclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Loader load the value of a key on cache miss, usually from the database
+type Loader func() (interface{}, error)
+
+// errLoaderPanic is the error of a load whose loader panicked
+var errLoaderPanic = errors.New("cache: loader panicked")
+
+// loadCall is one running loader, callers of the same key wait for it
+type loadCall struct {
+	done chan struct{}
+	val  []byte
+	err  error
+
+	// ctx of the load is done when all its callers stopped waiting, a
+	// background load has no callers and is never canceled
+	ctx        context.Context
+	cancel     context.CancelFunc
+	waiters    int
+	background bool
+}
+
+// loadGroup merge concurrent loads of the same key in this process
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+// do run fn once for the concurrent callers of key. Each caller returns
+// ctx.Err() when its own ctx is done first, fn keeps running for the
+// others with a ctx that is done only when no caller is waiting.
+func (g *loadGroup) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	c, running := g.add(key, false)
+	if !running {
+		go g.run(key, c, fn)
+	}
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.leave(c)
+		return nil, ctx.Err()
+	}
+}
+
+// start run fn in background unless a load of key is running
+func (g *loadGroup) start(key string, fn func(ctx context.Context) ([]byte, error)) {
+	c, running := g.add(key, true)
+	if !running {
+		go g.run(key, c, fn)
+	}
+}
+
+// add return the running load of key, or a new one the caller must run,
+// and count the caller unless background
+func (g *loadGroup) add(key string, background bool) (*loadCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	c, running := g.calls[key]
+	if !running {
+		c = &loadCall{done: make(chan struct{}), background: background}
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		g.calls[key] = c
+	}
+	if !background {
+		c.waiters++
+	}
+	return c, running
+}
+
+// leave stop waiting for c, the ctx of c is canceled with its last caller
+func (g *loadGroup) leave(c *loadCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c.waiters--
+	if c.waiters == 0 && !c.background {
+		c.cancel()
+	}
+}
+
+// run call fn for c, a panic of fn is recovered and is the error of the
+// load for all its callers
+func (g *loadGroup) run(key string, c *loadCall, fn func(ctx context.Context) ([]byte, error)) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.val, c.err = nil, fmt.Errorf("%w: %v", errLoaderPanic, p)
+			log.Printf("cache: load key=%q panicked: %v", key, p)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		c.cancel()
+		close(c.done)
+	}()
+
+	c.val, c.err = fn(c.ctx)
+}
+
+// unlockScript delete the lock only when it is still held by the token
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+const defaultLoadPollInterval = 50 * time.Millisecond
+
+// GetOrLoad get object from cache into out, on miss loader is called and
+// its result is set to cache with ttl. Concurrent misses of the same key
+// in this process call loader once. With Config.Loader.LockTTL set, a lock
+// key stops other processes from loading the key at the same time, they
+// poll the cache for the result instead. When loader returns ErrNotFound,
+// or an error wrapping it, the key is cached as missing, GetOrLoad returns
+// that error and then ErrNotFoundCached until the tombstone expires.
+func (cc *CacheClient) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader, out interface{}, opts ...LoadOption) error {
+	_, err := cc.GetOrLoadStale(ctx, key, ttl, loader, out, opts...)
+	return err
+}
+
+// GetOrLoadStale is GetOrLoad serving stale values: with Config.Stale.TTL
+// set, a value past its soft expiry is returned with stale true and loaded
+// again in background
+func (cc *CacheClient) GetOrLoadStale(ctx context.Context, key string, ttl time.Duration, loader Loader, out interface{}, opts ...LoadOption) (stale bool, err error) {
+	o := cc.loadOptions(opts)
+
+	b, err := cc.GetCtx(ctx, key).Bytes()
+	if err == nil && isTombstone(b) {
+		return false, cc.tombstoneHit()
+	}
+	if err == nil {
+		data, expiry, delta := openEnvelope(b)
+		switch {
+		case isStale(expiry):
+			atomic.AddUint64(&cc.stats.stale, 1)
+			cc.refresh(key, ttl, loader, o)
+			stale = true
+		case refreshEarly(time.Now(), expiry, delta, o.beta):
+			atomic.AddUint64(&cc.stats.earlyRefreshes, 1)
+			cc.refresh(key, ttl, loader, o)
+		}
+		return stale, cc.decode(key, data, out)
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != redis.Nil {
+		log.Printf("cache: GetOrLoad key=%q read failed, load it: %s", key, err)
+	}
+
+	// the load is shared, it stops waiting for the lock only when all
+	// callers of key are gone
+	data, err := cc.loads.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return cc.load(ctx, key, ttl, loader, o)
+	})
+	if err != nil {
+		return false, err
+	}
+	return false, cc.decode(key, data, out)
+}
+
+// load call loader under the cross process lock if enabled, and set the
+// result to cache. The time loader takes is kept with the value for early
+// refresh. ctx only stops the wait for the lock.
+func (cc *CacheClient) load(ctx context.Context, key string, ttl time.Duration, loader Loader, o loadOptions) ([]byte, error) {
+	conf := cc.config().Loader
+	if conf.LockTTL > 0 {
+		lockKey := key + ".lock"
+		token := newLockToken()
+
+		locked, err := cc.lock(lockKey, token, time.Duration(conf.LockTTL))
+		if err == nil && !locked {
+			b, ok, err := cc.waitLoaded(ctx, key, lockKey, conf)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if isTombstone(b) {
+					return nil, cc.tombstoneHit()
+				}
+				return b, nil
+			}
+			locked, err = cc.lock(lockKey, token, time.Duration(conf.LockTTL))
+		}
+		if err != nil {
+			log.Printf("cache: lock key=%q failed, load without lock: %s", lockKey, err)
+		}
+		if locked {
+			defer cc.unlock(lockKey, token)
+		}
+	}
+
+	start := time.Now()
+	v, err := loader()
+	if errors.Is(err, ErrNotFound) {
+		cc.SetNotFound(key, 0)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	var delta time.Duration
+	if o.beta > 0 {
+		// at least 1ns so the XFetch envelope is written
+		delta = time.Since(start) + 1
+	}
+
+	b, err := cc.encode(key, cc.codec(), v)
+	if err != nil {
+		return nil, err
+	}
+
+	// the loaded value is returned even if it can not be cached
+	value, ttl := cc.staleWrite(b, cc.ttlFor(key, ttl), delta)
+	cc.set(key, value, ttl)
+	return b, nil
+}
+
+// waitLoaded poll key until another process has loaded it. It gives up when
+// the lock is released without a value or after LockWait, and returns
+// ctx.Err() when ctx is done first.
+func (cc *CacheClient) waitLoaded(ctx context.Context, key, lockKey string, conf LoaderConfig) ([]byte, bool, error) {
+	wait := time.Duration(conf.LockWait)
+	if wait == 0 {
+		wait = time.Duration(conf.LockTTL)
+	}
+	interval := time.Duration(conf.PollInterval)
+	if interval == 0 {
+		interval = defaultLoadPollInterval
+	}
+
+	client := cc.acquire()
+	defer client.release()
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for deadline := time.Now().Add(wait); time.Now().Before(deadline); {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-timer.C:
+			timer.Reset(interval)
+		}
+
+		b, err := client.Get(key).Bytes()
+		if err == nil {
+			return b, true, nil
+		}
+		if err != redis.Nil {
+			return nil, false, nil
+		}
+		if n, err := client.Exists(lockKey).Result(); err != nil || n == 0 {
+			return nil, false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (cc *CacheClient) lock(lockKey, token string, ttl time.Duration) (bool, error) {
+	client := cc.acquire()
+	defer client.release()
+
+	return client.SetNX(lockKey, token, ttl).Result()
+}
+
+func (cc *CacheClient) unlock(lockKey, token string) {
+	client := cc.acquire()
+	defer client.release()
+
+	if err := unlockScript.Run(client, []string{lockKey}, token).Err(); err != nil {
+		log.Printf("cache: unlock key=%q failed: %s", lockKey, err)
+	}
+}
+
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// decode unmarshal a cached value into out
+func (cc *CacheClient) decode(key string, b []byte, out interface{}) error {
+	if err := decodeObject(b, out, cc.config().Compression.MaxSize); err != nil {
+		log.Printf("cache: key=%q Unmarshal(%T) failed: %s", key, out, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package cacheclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_loadGroup(t *testing.T) {
+	var g loadGroup
+	var calls int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := g.do(context.Background(), "key1", func(context.Context) ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(100 * time.Millisecond)
+				return []byte("v1"), nil
+			})
+			if err != nil || string(b) != "v1" {
+				t.Error("loadGroup result error", string(b), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Error("concurrent loads should be merged", calls)
+	}
+
+	_, err := g.do(context.Background(), "key1", func(context.Context) ([]byte, error) {
+		return nil, errors.New("load error")
+	})
+	if err == nil || len(g.calls) != 0 {
+		t.Error("finished load should be removed", err, len(g.calls))
+	}
+}
+
+// a caller stops waiting for the loader of another when its ctx is done,
+// the loader still finishes for the others
+func Test_loadGroup_Context(t *testing.T) {
+	var g loadGroup
+	release := make(chan struct{})
+	loaded := make(chan []byte)
+	go func() {
+		b, _ := g.do(context.Background(), "key1", func(context.Context) ([]byte, error) {
+			<-release
+			return []byte("v1"), nil
+		})
+		loaded <- b
+	}()
+	for {
+		g.mu.Lock()
+		n := len(g.calls)
+		g.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := g.do(ctx, "key1", func(context.Context) ([]byte, error) {
+		t.Error("loader should not run twice")
+		return nil, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Error("waiter should return ctx.Err()", err)
+	}
+
+	close(release)
+	if b := <-loaded; string(b) != "v1" {
+		t.Error("loader should finish", string(b))
+	}
+}
+
+// the first caller of a load going away does not fail the others, the load
+// is canceled only when no caller waits for it
+func Test_loadGroup_LeaderCanceled(t *testing.T) {
+	var g loadGroup
+	release := make(chan struct{})
+	loadCtx := make(chan context.Context, 1)
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := g.do(leaderCtx, "key1", func(ctx context.Context) ([]byte, error) {
+			loadCtx <- ctx
+			select {
+			case <-release:
+				return []byte("v1"), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+		leader <- err
+	}()
+	ctx := <-loadCtx
+
+	waiter := make(chan []byte)
+	go func() {
+		b, err := g.do(context.Background(), "key1", func(context.Context) ([]byte, error) {
+			t.Error("loader should not run twice")
+			return nil, nil
+		})
+		if err != nil {
+			t.Error("waiter should get the load result", err)
+		}
+		waiter <- b
+	}()
+	for {
+		g.mu.Lock()
+		n := g.calls["key1"].waiters
+		g.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelLeader()
+	if err := <-leader; err != context.Canceled {
+		t.Error("leader should return ctx.Err()", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("load should go on for the waiter", ctx.Err())
+	}
+	close(release)
+	if b := <-waiter; string(b) != "v1" {
+		t.Error("waiter should get the loaded value", string(b))
+	}
+
+	// the only caller going away cancels the load
+	callerCtx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan struct{})
+	_, err := g.do(callerCtx, "key2", func(ctx context.Context) ([]byte, error) {
+		defer close(canceled)
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Error("caller should return ctx.Err()", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("load should be canceled with its last caller")
+	}
+}
+
+// a panicking loader fails its load instead of the process
+func Test_loadGroup_Panic(t *testing.T) {
+	var g loadGroup
+	_, err := g.do(context.Background(), "key1", func(context.Context) ([]byte, error) {
+		panic("db gone")
+	})
+	if !errors.Is(err, errLoaderPanic) || !strings.Contains(err.Error(), "db gone") {
+		t.Error("load should fail with errLoaderPanic", err)
+	}
+
+	done := make(chan struct{})
+	g.start("key1", func(context.Context) ([]byte, error) {
+		defer close(done)
+		panic("db gone")
+	})
+	<-done
+	for {
+		g.mu.Lock()
+		n := len(g.calls)
+		g.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	b, err := g.do(context.Background(), "key1", func(context.Context) ([]byte, error) {
+		return []byte("v1"), nil
+	})
+	if err != nil || string(b) != "v1" {
+		t.Error("key should load after a panic", string(b), err)
+	}
+}
+
+// server is down, GetOrLoad still returns the loaded value
+func Test_GetOrLoad_ServerDown(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	var out object
+	err = cc.GetOrLoad(context.Background(), "object", time.Minute, func() (interface{}, error) {
+		return &object{Str: "test", Num: 1}, nil
+	}, &out)
+	if err != nil || out.Str != "test" || out.Num != 1 {
+		t.Error("GetOrLoad error", err, out)
+	}
+
+	errLoad := errors.New("db error")
+	err = cc.GetOrLoad(context.Background(), "object", time.Minute, func() (interface{}, error) {
+		return nil, errLoad
+	}, &out)
+	if err != errLoad {
+		t.Error("GetOrLoad should return loader error", err)
+	}
+}
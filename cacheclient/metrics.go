@@ -0,0 +1,196 @@
+package cacheclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the exported latency
+// histograms
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// MetricsHandler return a http.Handler writing the metrics of the client in
+// the Prometheus text exposition format, shards are labeled by their names
+// in redis.json and their state is the one of the last heartbeat
+func (cc *CacheClient) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, cc.Snapshot(), cc.lastShardStates())
+	})
+}
+
+// metricsWriter write metric families, the errors are kept until flush
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (m *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample write one sample, labels are name and value pairs
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+// histogram write the buckets, sum and count of a latency histogram
+func (m *metricsWriter) histogram(name string, c *histCounts, sum time.Duration, count int64, labels ...string) {
+	var n uint64
+	i := 0
+	for _, bound := range latencyBuckets {
+		// le is inclusive, a bucket whose largest value is the bound is in
+		us := int64(math.Round(bound * 1e6))
+		for ; i < histBuckets && histUpper(i) <= us; i++ {
+			if c != nil {
+				n += c[i]
+			}
+		}
+		m.sample(name+"_bucket", float64(n), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	m.sample(name+"_bucket", float64(count), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", sum.Seconds(), labels...)
+	m.sample(name+"_count", float64(count), labels...)
+}
+
+// breakerOpenValue is the cacheclient_shard_breaker_open value of a state
+var breakerOpenValue = map[string]float64{"closed": 0, "half-open": 0.5, "open": 1}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func writeMetrics(w io.Writer, st Stats, shards []shardState) error {
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+
+	m.family("cacheclient_hits_total", "counter", "Reads found in the near cache or Redis.")
+	m.sample("cacheclient_hits_total", float64(st.Hits))
+	m.family("cacheclient_misses_total", "counter", "Reads not found in Redis.")
+	m.sample("cacheclient_misses_total", float64(st.Misses))
+	m.family("cacheclient_near_hits_total", "counter", "Reads found in the near cache.")
+	m.sample("cacheclient_near_hits_total", float64(st.L1Hits))
+	m.family("cacheclient_stale_reads_total", "counter", "Object reads served past their soft expiry.")
+	m.sample("cacheclient_stale_reads_total", float64(st.Stale))
+	m.family("cacheclient_early_refreshes_total", "counter", "Loads started early by XFetch.")
+	m.sample("cacheclient_early_refreshes_total", float64(st.EarlyRefreshes))
+	m.family("cacheclient_tombstone_hits_total", "counter", "Object reads of keys cached as missing.")
+	m.sample("cacheclient_tombstone_hits_total", float64(st.TombstoneHits))
+
+	ops := make([]string, 0, len(st.Ops))
+	for name := range st.Ops {
+		ops = append(ops, name)
+	}
+	sort.Strings(ops)
+
+	m.family("cacheclient_requests_total", "counter", "Calls by operation, a batch is one call.")
+	for _, name := range ops {
+		m.sample("cacheclient_requests_total", float64(st.Ops[name].Calls), "op", name)
+	}
+	m.family("cacheclient_errors_total", "counter", "Failed calls by operation.")
+	for _, name := range ops {
+		m.sample("cacheclient_errors_total", float64(st.Ops[name].Errors), "op", name)
+	}
+	m.family("cacheclient_timeouts_total", "counter", "Timed out calls by operation.")
+	for _, name := range ops {
+		m.sample("cacheclient_timeouts_total", float64(st.Ops[name].Timeouts), "op", name)
+	}
+	m.family("cacheclient_request_duration_seconds", "histogram", "Latency of calls by operation.")
+	for _, name := range ops {
+		o := st.Ops[name]
+		m.histogram("cacheclient_request_duration_seconds", o.latency, o.Elapse, o.Calls, "op", name)
+	}
+
+	names := make([]string, 0, len(st.Shards))
+	for name := range st.Shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.family("cacheclient_shard_requests_total", "counter", "Single key calls by shard.")
+	for _, name := range names {
+		m.sample("cacheclient_shard_requests_total", float64(st.Shards[name].Calls), "shard", name)
+	}
+	m.family("cacheclient_shard_errors_total", "counter", "Failed single key calls by shard.")
+	for _, name := range names {
+		m.sample("cacheclient_shard_errors_total", float64(st.Shards[name].Errors), "shard", name)
+	}
+	m.family("cacheclient_shard_timeouts_total", "counter", "Timed out single key calls by shard.")
+	for _, name := range names {
+		m.sample("cacheclient_shard_timeouts_total", float64(st.Shards[name].Timeouts), "shard", name)
+	}
+	m.family("cacheclient_shard_rejected_total", "counter", "Calls failed by the shard breaker.")
+	for _, name := range names {
+		m.sample("cacheclient_shard_rejected_total", float64(st.Shards[name].Rejected), "shard", name)
+	}
+	m.family("cacheclient_shard_breaker_open", "gauge", "1 if the shard breaker is open, 0.5 if half-open.")
+	for _, name := range names {
+		if b := st.Shards[name].Breaker; b != "" {
+			m.sample("cacheclient_shard_breaker_open", breakerOpenValue[b], "shard", name)
+		}
+	}
+	m.family("cacheclient_shard_request_duration_seconds", "histogram", "Latency of single key calls by shard.")
+	for _, name := range names {
+		s := st.Shards[name]
+		m.histogram("cacheclient_shard_request_duration_seconds", s.latency, s.Elapse, s.Calls, "shard", name)
+	}
+
+	m.family("cacheclient_shard_up", "gauge", "1 if the shard is in use, 0 if it is down.")
+	for _, s := range shards {
+		up := 0.0
+		if s.Up {
+			up = 1
+		}
+		m.sample("cacheclient_shard_up", up, "shard", s.Name, "addr", s.Addr)
+	}
+
+	type poolMetric struct {
+		name, typ, help string
+		value           func(s shardState) uint32
+	}
+	pools := []poolMetric{
+		{"cacheclient_pool_hits_total", "counter", "Free connections found in the pool.",
+			func(s shardState) uint32 { return s.Pool.Hits }},
+		{"cacheclient_pool_misses_total", "counter", "Free connections not found in the pool.",
+			func(s shardState) uint32 { return s.Pool.Misses }},
+		{"cacheclient_pool_timeouts_total", "counter", "Waits for a connection timed out.",
+			func(s shardState) uint32 { return s.Pool.Timeouts }},
+		{"cacheclient_pool_stale_conns_total", "counter", "Stale connections removed from the pool.",
+			func(s shardState) uint32 { return s.Pool.StaleConns }},
+		{"cacheclient_pool_conns", "gauge", "Connections in the pool.",
+			func(s shardState) uint32 { return s.Pool.TotalConns }},
+		{"cacheclient_pool_idle_conns", "gauge", "Free connections in the pool.",
+			func(s shardState) uint32 { return s.Pool.FreeConns }},
+	}
+	for _, p := range pools {
+		m.family(p.name, p.typ, p.help)
+		for _, s := range shards {
+			// a ring shard that is down is not reachable
+			if s.Pool != nil {
+				m.sample(p.name, float64(p.value(s)), "shard", s.Name)
+			}
+		}
+	}
+
+	return m.w.Flush()
+}
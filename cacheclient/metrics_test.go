@@ -0,0 +1,106 @@
+package cacheclient
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_writeMetrics(t *testing.T) {
+	var h histogram
+	h.add(200 * time.Microsecond)
+	h.add(3 * time.Millisecond)
+
+	st := Stats{
+		Hits:   3,
+		Misses: 1,
+		Ops:    map[string]OpStats{"Get": {Calls: 2, Errors: 1, Elapse: 3200 * time.Microsecond, latency: h.load()}},
+		Shards: map[string]ShardStats{"server1": {Calls: 2, Elapse: 3200 * time.Microsecond, Breaker: "open", Rejected: 4, latency: h.load()}},
+	}
+	shards := []shardState{
+		{Name: "server1", Addr: "127.0.0.1:6379", Up: true, Pool: &redis.PoolStats{TotalConns: 5}},
+		{Name: "server2", Addr: "127.0.0.1:6380"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, st, shards); err != nil {
+		t.Fatal("writeMetrics error", err)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE cacheclient_hits_total counter",
+		"cacheclient_hits_total 3",
+		"cacheclient_misses_total 1",
+		`cacheclient_errors_total{op="Get"} 1`,
+		`cacheclient_request_duration_seconds_bucket{op="Get",le="0.00025"} 1`,
+		`cacheclient_request_duration_seconds_bucket{op="Get",le="0.005"} 2`,
+		`cacheclient_request_duration_seconds_bucket{op="Get",le="+Inf"} 2`,
+		`cacheclient_request_duration_seconds_sum{op="Get"} 0.0032`,
+		`cacheclient_shard_requests_total{shard="server1"} 2`,
+		`cacheclient_shard_rejected_total{shard="server1"} 4`,
+		`cacheclient_shard_breaker_open{shard="server1"} 1`,
+		`cacheclient_shard_up{shard="server1",addr="127.0.0.1:6379"} 1`,
+		`cacheclient_shard_up{shard="server2",addr="127.0.0.1:6380"} 0`,
+		`cacheclient_pool_conns{shard="server1"} 5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("metrics should contain", line)
+		}
+	}
+	if strings.Contains(out, `cacheclient_pool_conns{shard="server2"}`) {
+		t.Error("down shard should have no pool metrics")
+	}
+}
+
+// le is inclusive, a latency equal to a bound is counted in its bucket
+func Test_writeMetrics_BucketBound(t *testing.T) {
+	defer func(buckets []float64) { latencyBuckets = buckets }(latencyBuckets)
+	// 255µs is the largest value of its histogram bucket
+	latencyBuckets = []float64{0.000255}
+
+	var h histogram
+	h.add(255 * time.Microsecond)
+	st := Stats{Ops: map[string]OpStats{"Get": {Calls: 1, Elapse: 255 * time.Microsecond, latency: h.load()}}}
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, st, nil); err != nil {
+		t.Fatal("writeMetrics error", err)
+	}
+	line := `cacheclient_request_duration_seconds_bucket{op="Get",le="0.000255"} 1`
+	if !strings.Contains(buf.String(), line+"\n") {
+		t.Error("metrics should contain", line)
+	}
+}
+
+func Test_escapeLabel(t *testing.T) {
+	if v := escapeLabel("a\"b\\c\nd"); v != `a\"b\\c\nd` {
+		t.Error("escapeLabel error", v)
+	}
+}
+
+// server is down, the handler still serves the client metrics
+func Test_MetricsHandler(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	cc.Get("key1")
+
+	rec := httptest.NewRecorder()
+	cc.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	if !strings.Contains(out, `cacheclient_shard_up{shard="127.0.0.1:1",addr="127.0.0.1:1"} 0`) {
+		t.Error("shard should be down", out)
+	}
+	if !strings.Contains(out, `cacheclient_shard_errors_total{shard="127.0.0.1:1"} 1`) {
+		t.Error("shard errors error", out)
+	}
+}
@@ -0,0 +1,198 @@
+package cacheclient
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// nearCache is the in-process L1 cache in front of Redis, a LRU of at most
+// size entries, each kept at most maxTTL
+type nearCache struct {
+	mu      sync.Mutex
+	size    int
+	maxTTL  time.Duration
+	ll      *list.List
+	entries map[string]*list.Element
+
+	// seq orders the Redis reads and the changes of keys, a read fills the
+	// near cache only when its key has not changed since the read started
+	seq     uint64
+	flushed uint64
+	reads   map[string]*nearRead
+}
+
+type nearEntry struct {
+	key    string
+	value  string
+	expire time.Time
+}
+
+// nearRead is the Redis reads of a key running
+type nearRead struct {
+	n int
+	// changed is the seq of the last change of the key during the reads
+	changed uint64
+}
+
+// newNearCache return nil when c disables the near cache
+func newNearCache(c NearCacheConfig) *nearCache {
+	if c.Size <= 0 {
+		return nil
+	}
+	return &nearCache{
+		size:    c.Size,
+		maxTTL:  time.Duration(c.MaxTTL),
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+		reads:   make(map[string]*nearRead),
+	}
+}
+
+func (nc *nearCache) get(key string) (string, bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	e, ok := nc.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := e.Value.(*nearEntry)
+	if time.Now().After(entry.expire) {
+		nc.removeElement(e)
+		return "", false
+	}
+	nc.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+// begin start a Redis read of key, the token returned is passed to fill
+func (nc *nearCache) begin(key string) uint64 {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	r, ok := nc.reads[key]
+	if !ok {
+		r = new(nearRead)
+		nc.reads[key] = r
+	}
+	r.n++
+	nc.seq++
+	return nc.seq
+}
+
+// fill end the read of key started with token, and add value when it was
+// found and the key has not been set, deleted or flushed since
+func (nc *nearCache) fill(key string, token uint64, value string, found bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	r := nc.reads[key]
+	valid := r.changed < token && nc.flushed < token
+	if r.n--; r.n == 0 {
+		delete(nc.reads, key)
+	}
+	if found && valid {
+		nc.setLocked(key, value, 0)
+	}
+}
+
+// changedLocked make the running reads of key skip their fill
+func (nc *nearCache) changedLocked(key string) {
+	nc.seq++
+	if r, ok := nc.reads[key]; ok {
+		r.changed = nc.seq
+	}
+}
+
+// set add or update key, ttl is capped at maxTTL and 0 means maxTTL
+func (nc *nearCache) set(key, value string, ttl time.Duration) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.changedLocked(key)
+	nc.setLocked(key, value, ttl)
+}
+
+func (nc *nearCache) setLocked(key, value string, ttl time.Duration) {
+	if ttl <= 0 || ttl > nc.maxTTL {
+		ttl = nc.maxTTL
+	}
+	expire := time.Now().Add(ttl)
+
+	if e, ok := nc.entries[key]; ok {
+		entry := e.Value.(*nearEntry)
+		entry.value, entry.expire = value, expire
+		nc.ll.MoveToFront(e)
+		return
+	}
+
+	nc.entries[key] = nc.ll.PushFront(&nearEntry{key: key, value: value, expire: expire})
+	for nc.ll.Len() > nc.size {
+		nc.removeElement(nc.ll.Back())
+	}
+}
+
+func (nc *nearCache) del(key string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.changedLocked(key)
+	if e, ok := nc.entries[key]; ok {
+		nc.removeElement(e)
+	}
+}
+
+// flush drop all entries
+func (nc *nearCache) flush() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.ll.Init()
+	nc.entries = make(map[string]*list.Element)
+	nc.seq++
+	nc.flushed = nc.seq
+}
+
+func (nc *nearCache) len() int {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.ll.Len()
+}
+
+func (nc *nearCache) removeElement(e *list.Element) {
+	nc.ll.Remove(e)
+	delete(nc.entries, e.Value.(*nearEntry).key)
+}
+
+// nearCache return the near cache of the client, nil when disabled
+func (cc *CacheClient) nearCache() *nearCache {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.near
+}
+
+// nearSet update the near cache after value is written to Redis, values
+// not kept as a string are dropped from the near cache instead
+func (cc *CacheClient) nearSet(key string, value interface{}, ttl time.Duration) {
+	nc := cc.nearCache()
+	if nc == nil {
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		nc.set(key, v, ttl)
+	case []byte:
+		nc.set(key, string(v), ttl)
+	default:
+		nc.del(key)
+	}
+}
+
+// nearDel drop key from the near cache
+func (cc *CacheClient) nearDel(key string) {
+	if nc := cc.nearCache(); nc != nil {
+		nc.del(key)
+	}
+}
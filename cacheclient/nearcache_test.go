@@ -0,0 +1,113 @@
+package cacheclient
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_nearCache(t *testing.T) {
+	nc := newNearCache(NearCacheConfig{Size: 2, MaxTTL: Duration(50 * time.Millisecond)})
+
+	nc.set("key1", "v1", 0)
+	nc.set("key2", "v2", time.Hour)
+	nc.get("key1")
+	nc.set("key3", "v3", 0)
+
+	// key2 is the least recently used
+	if _, ok := nc.get("key2"); ok || nc.len() != 2 {
+		t.Error("LRU entry should be evicted", nc.len())
+	}
+	if v, ok := nc.get("key1"); !ok || v != "v1" {
+		t.Error("near cache get error", v)
+	}
+
+	// ttl is capped at MaxTTL
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := nc.get("key3"); ok {
+		t.Error("entry should expire after MaxTTL")
+	}
+
+	nc.set("key1", "v1", 0)
+	nc.del("key1")
+	if _, ok := nc.get("key1"); ok {
+		t.Error("deleted entry should be gone")
+	}
+
+	if newNearCache(NearCacheConfig{}) != nil {
+		t.Error("near cache should be disabled by default")
+	}
+}
+
+// a read racing with a write or an invalidation does not fill the old value
+func Test_nearCache_fill(t *testing.T) {
+	nc := newNearCache(NearCacheConfig{Size: 10, MaxTTL: Duration(time.Minute)})
+
+	token := nc.begin("key1")
+	nc.fill("key1", token, "v1", true)
+	if v, ok := nc.get("key1"); !ok || v != "v1" {
+		t.Error("read should fill the near cache", v)
+	}
+
+	for name, change := range map[string]func(){
+		"set":   func() { nc.set("key1", "v2", 0) },
+		"del":   func() { nc.del("key1") },
+		"flush": nc.flush,
+	} {
+		nc.del("key1")
+		token = nc.begin("key1")
+		// another read starting after the change may fill
+		change()
+		later := nc.begin("key1")
+		nc.fill("key1", token, "old", true)
+		if v, _ := nc.get("key1"); v == "old" {
+			t.Error("read should not fill after", name)
+		}
+		nc.fill("key1", later, "new", true)
+		if v, _ := nc.get("key1"); v != "new" {
+			t.Error("later read should fill after", name, v)
+		}
+	}
+
+	token = nc.begin("key2")
+	nc.fill("key2", token, "", false)
+	if _, ok := nc.get("key2"); ok || len(nc.reads) != 0 {
+		t.Error("missed read should not fill", len(nc.reads))
+	}
+}
+
+// server is down, keys in the near cache are still served
+func Test_nearCache_Client(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithNearCache(100, time.Minute))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	cc.nearCache().set("key1", "v1", 0)
+	if v, err := cc.GetString("key1"); err != nil || v != "v1" {
+		t.Error("key1 should be read from near cache", v, err)
+	}
+
+	kvs, err := cc.GetStrings([]string{"key1"})
+	if err != nil || kvs["key1"] != "v1" {
+		t.Error("batch get should read near cache", kvs, err)
+	}
+
+	// a failed write must not leave the old value in the near cache
+	cc.SetString("key1", "v2", 0)
+	if _, ok := cc.nearCache().get("key1"); ok {
+		t.Error("failed Set should invalidate near cache")
+	}
+
+	cc.nearCache().set("key1", "v1", 0)
+	cc.Del("key1")
+	if _, ok := cc.nearCache().get("key1"); ok {
+		t.Error("Del should invalidate near cache")
+	}
+
+	if cc.stats.l1Hits != 2 {
+		t.Error("l1 hits error", cc.stats.l1Hits)
+	}
+}
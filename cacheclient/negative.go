@@ -0,0 +1,49 @@
+package cacheclient
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// A key known to be missing in the database is cached as a tombstone for
+// a short ttl, so its reads stop falling through to the database. Object
+// reads of a tombstone return ErrNotFoundCached.
+
+var (
+	// ErrNotFound is returned, or wrapped, by a Loader when the key does
+	// not exist, a tombstone is cached for it
+	ErrNotFound = errors.New("cache: not found")
+	// ErrNotFoundCached is returned by object reads of a tombstone
+	ErrNotFoundCached = errors.New("cache: not found, cached")
+)
+
+// tombstoneID is the whole value of a tombstone. Like the codec ids it is
+// below 0x20, and it is not a JSON whitespace.
+const tombstoneID byte = 0x0c
+
+// defaultNegativeTTL is the ttl of a tombstone when Config.Negative.TTL is
+// not set
+const defaultNegativeTTL = 30 * time.Second
+
+func isTombstone(b []byte) bool {
+	return len(b) == 1 && b[0] == tombstoneID
+}
+
+// SetNotFound cache key as missing, object reads of it return
+// ErrNotFoundCached until ttl, 0 is Config.Negative.TTL
+func (cc *CacheClient) SetNotFound(key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = time.Duration(cc.config().Negative.TTL)
+	}
+	if ttl <= 0 {
+		ttl = defaultNegativeTTL
+	}
+	return cc.set(key, []byte{tombstoneID}, ttl)
+}
+
+// tombstoneHit count a read of a tombstone and return ErrNotFoundCached
+func (cc *CacheClient) tombstoneHit() error {
+	atomic.AddUint64(&cc.stats.tombstoneHits, 1)
+	return ErrNotFoundCached
+}
@@ -0,0 +1,105 @@
+package cacheclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_NegativeCache(t *testing.T) {
+	cc, srv := newTestClient(t, WithNegativeTTL(5*time.Second))
+
+	var calls int
+	loader := func() (interface{}, error) {
+		calls++
+		return nil, ErrNotFound
+	}
+
+	var v string
+	if err := cc.GetOrLoad(context.Background(), "user:1", time.Minute, loader, &v); err != ErrNotFound {
+		t.Error("GetOrLoad should return ErrNotFound", err)
+	}
+	if ttl := srv.ttl("user:1"); ttl <= 0 || ttl > 5*time.Second {
+		t.Error("tombstone should have the negative TTL", ttl)
+	}
+	if err := cc.GetOrLoad(context.Background(), "user:1", time.Minute, loader, &v); err != ErrNotFoundCached || calls != 1 {
+		t.Error("GetOrLoad should return ErrNotFoundCached without loading", err, calls)
+	}
+
+	if err := cc.GetObject("user:1", &v); err != ErrNotFoundCached {
+		t.Error("GetObject should return ErrNotFoundCached", err)
+	}
+	if err := cc.GetObjectCtx(context.Background(), "user:1", &v); err != ErrNotFoundCached {
+		t.Error("GetObjectCtx should return ErrNotFoundCached", err)
+	}
+	if err := cc.GetObject("user:2", &v); err == ErrNotFoundCached {
+		t.Error("key not in cache should not be ErrNotFoundCached", err)
+	}
+
+	cc.SetObjectWithTTL("user:3", "v3", time.Minute)
+	kvs, misses, err := cc.GetObjectsInto([]string{"user:1", "user:2", "user:3"}, func() interface{} { return new(string) })
+	if v3, ok := kvs["user:3"].(*string); err != nil || len(kvs) != 1 || !ok || *v3 != "v3" || len(misses) != 1 || !misses.Has("user:2") {
+		t.Error("GetObjectsInto should skip the tombstone", kvs, misses, err)
+	}
+
+	if st := cc.Snapshot(); st.TombstoneHits != 4 {
+		t.Error("tombstone hits error", st.TombstoneHits)
+	}
+}
+
+func Test_SetNotFound(t *testing.T) {
+	cc, srv := newTestClient(t)
+
+	if err := cc.SetNotFound("user:1", 0); err != nil {
+		t.Fatal("SetNotFound error", err)
+	}
+	if ttl := srv.ttl("user:1"); ttl <= 0 || ttl > defaultNegativeTTL {
+		t.Error("tombstone should have the default negative TTL", ttl)
+	}
+	var v string
+	if err := cc.GetObject("user:1", &v); err != ErrNotFoundCached {
+		t.Error("GetObject should return ErrNotFoundCached", err)
+	}
+}
+
+// a wrapped ErrNotFound caches a tombstone, and a tombstone loaded by
+// another process while waiting for its lock counts as a hit
+func Test_NegativeCache_Wrapped(t *testing.T) {
+	cc, srv := newTestClient(t, WithLoadLock(time.Second, time.Second, 10*time.Millisecond))
+
+	var v string
+	errMissing := fmt.Errorf("user 1: %w", ErrNotFound)
+	err := cc.GetOrLoad(context.Background(), "user:1", time.Minute, func() (interface{}, error) {
+		return nil, errMissing
+	}, &v)
+	if err != errMissing {
+		t.Error("GetOrLoad should return the loader error", err)
+	}
+	if err := cc.GetObject("user:1", &v); err != ErrNotFoundCached {
+		t.Error("wrapped ErrNotFound should cache a tombstone", err)
+	}
+
+	// another process holds the lock and writes the tombstone
+	srv.mu.Lock()
+	srv.data["user:2.lock"] = "other"
+	srv.after = func(args []string) {
+		if strings.ToLower(args[0]) == "set" && args[1] == "user:2.lock" {
+			srv.data["user:2"] = string([]byte{tombstoneID})
+			srv.after = nil
+		}
+	}
+	srv.mu.Unlock()
+	hits := cc.Snapshot().TombstoneHits
+	err = cc.GetOrLoad(context.Background(), "user:2", time.Minute, func() (interface{}, error) {
+		t.Error("loader should not run while another process loads")
+		return nil, nil
+	}, &v)
+	if err != ErrNotFoundCached {
+		t.Error("GetOrLoad should return ErrNotFoundCached", err)
+	}
+	if st := cc.Snapshot(); st.TombstoneHits != hits+1 {
+		t.Error("tombstone read while waiting should count as a hit", st.TombstoneHits-hits)
+	}
+}
@@ -0,0 +1,205 @@
+package cacheclient
+
+import "time"
+
+// Option change a Config before the CacheClient is built
+type Option func(*Config)
+
+// WithHashType set the backend type: ring, cluster, sentinel or single
+func WithHashType(hashType string) Option {
+	return func(c *Config) {
+		c.HashType = hashType
+	}
+}
+
+// WithAddrs set the server addresses, see Config.Addrs for the format
+func WithAddrs(addrs ...string) Option {
+	return func(c *Config) {
+		c.Addrs = append([]string(nil), addrs...)
+	}
+}
+
+// WithMasterName set the sentinel master name
+func WithMasterName(name string) Option {
+	return func(c *Config) {
+		c.MasterName = name
+	}
+}
+
+// WithDB set the database selected after connecting
+func WithDB(db int) Option {
+	return func(c *Config) {
+		c.DB = db
+	}
+}
+
+// WithPassword set the server password
+func WithPassword(password string) Option {
+	return func(c *Config) {
+		c.Password = password
+	}
+}
+
+// WithMaxRetries set the max retries of a failed command
+func WithMaxRetries(n int) Option {
+	return func(c *Config) {
+		c.MaxRetries = n
+	}
+}
+
+// WithCodec set the codec used by SetObject, by name
+func WithCodec(name string) Option {
+	return func(c *Config) {
+		c.Codec = name
+	}
+}
+
+// WithCompression compress objects larger than threshold bytes with the
+// named compressor
+func WithCompression(name string, threshold int) Option {
+	return func(c *Config) {
+		c.Compression.Type = name
+		c.Compression.Threshold = threshold
+	}
+}
+
+// WithDecompressLimit fail to decode objects larger than max bytes once
+// decompressed
+func WithDecompressLimit(max int) Option {
+	return func(c *Config) {
+		c.Compression.MaxSize = max
+	}
+}
+
+// WithLoadLock enable the cross process lock of GetOrLoad
+func WithLoadLock(ttl, wait, pollInterval time.Duration) Option {
+	return func(c *Config) {
+		c.Loader.LockTTL = Duration(ttl)
+		c.Loader.LockWait = Duration(wait)
+		c.Loader.PollInterval = Duration(pollInterval)
+	}
+}
+
+// WithNearCache enable the in-process near cache of at most size keys,
+// each kept at most maxTTL
+func WithNearCache(size int, maxTTL time.Duration) Option {
+	return func(c *Config) {
+		c.NearCache.Size = size
+		c.NearCache.MaxTTL = Duration(maxTTL)
+	}
+}
+
+// WithInvalidationChannel set the Pub/Sub channel that keeps the near
+// caches of all clients in sync
+func WithInvalidationChannel(channel string) Option {
+	return func(c *Config) {
+		c.NearCache.Channel = channel
+	}
+}
+
+// WithHotKeys enable hot key detection, size keys are counted in each
+// window and sampleRate of the accesses are counted, 0 means all
+func WithHotKeys(size int, window time.Duration, sampleRate float64) Option {
+	return func(c *Config) {
+		c.HotKeys.Size = size
+		c.HotKeys.Window = Duration(window)
+		c.HotKeys.SampleRate = sampleRate
+	}
+}
+
+// WithHotKeyReplicas keep n copies of hot keys on other shards, keys are
+// hot when marked or detected above qps, copies live at most ttl
+func WithHotKeyReplicas(n int, qps float64, ttl time.Duration) Option {
+	return func(c *Config) {
+		c.HotKeys.Replicas = n
+		c.HotKeys.ReplicaQPS = qps
+		c.HotKeys.ReplicaTTL = Duration(ttl)
+	}
+}
+
+// WithBreaker enable the shard breakers, a breaker opens when errorRate of
+// at least minCalls calls in a window fail and stays open for openTime
+func WithBreaker(window time.Duration, minCalls int, errorRate float64, openTime time.Duration) Option {
+	return func(c *Config) {
+		c.Breaker.Window = Duration(window)
+		c.Breaker.MinCalls = minCalls
+		c.Breaker.ErrorRate = errorRate
+		c.Breaker.OpenTime = Duration(openTime)
+	}
+}
+
+// WithSlowCallBreaker also open the shard breakers when rate of the calls
+// take longer than slowCall
+func WithSlowCallBreaker(slowCall time.Duration, rate float64) Option {
+	return func(c *Config) {
+		c.Breaker.SlowCall = Duration(slowCall)
+		c.Breaker.SlowRate = rate
+	}
+}
+
+// WithServeStale keep objects in Redis for ttl after their own ttl and
+// serve them stale meanwhile
+func WithServeStale(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.Stale.TTL = Duration(ttl)
+	}
+}
+
+// WithTTLJitter move every ttl by jitter at random, 0.1 is ±10%
+func WithTTLJitter(jitter float64) Option {
+	return func(c *Config) {
+		c.TTL.Jitter = jitter
+	}
+}
+
+// WithTTLPolicy set the ttl policy of the keys with prefix, 0 is not set
+func WithTTLPolicy(prefix string, def, min, max time.Duration) Option {
+	return func(c *Config) {
+		prefixes := make(map[string]TTLPolicy, len(c.TTL.Prefixes)+1)
+		for k, v := range c.TTL.Prefixes {
+			prefixes[k] = v
+		}
+		prefixes[prefix] = TTLPolicy{Default: Duration(def), Min: Duration(min), Max: Duration(max)}
+		c.TTL.Prefixes = prefixes
+	}
+}
+
+// WithNegativeTTL set the ttl of the tombstones of missing keys
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.Negative.TTL = Duration(ttl)
+	}
+}
+
+// WithHeartbeatFrequency set how often ring shards are checked
+func WithHeartbeatFrequency(d time.Duration) Option {
+	return func(c *Config) {
+		c.HeartbeatFrequency = Duration(d)
+	}
+}
+
+// WithStatsInterval set the stats interval
+func WithStatsInterval(d time.Duration) Option {
+	return func(c *Config) {
+		c.Stats.Interval = Duration(d)
+	}
+}
+
+// WithTimeouts set the dial, read and write timeouts
+func WithTimeouts(dial, read, write time.Duration) Option {
+	return func(c *Config) {
+		c.ConnTimeout.DialTimeout = Duration(dial)
+		c.ConnTimeout.ReadTimeout = Duration(read)
+		c.ConnTimeout.WriteTimeout = Duration(write)
+	}
+}
+
+// WithPool set the per shard connection pool
+func WithPool(size int, timeout, idleTimeout, idleCheckFrequency time.Duration) Option {
+	return func(c *Config) {
+		c.Pool.PoolSize = size
+		c.Pool.PoolTimeout = Duration(timeout)
+		c.Pool.IdleTimeout = Duration(idleTimeout)
+		c.Pool.IdleCheckFrequency = Duration(idleCheckFrequency)
+	}
+}
@@ -0,0 +1,28 @@
+package cacheclient
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_NewCacheClientWithConfig(t *testing.T) {
+	cfg := Config{HashType: HashTypeSingle, Addrs: []string{"127.0.0.1:6379"}}
+
+	cc, err := NewCacheClientWithConfig(cfg,
+		WithAddrs("cache1:127.0.0.1:6380"),
+		WithPool(8, 2*time.Second, time.Minute, time.Minute),
+		WithTimeouts(time.Second, 3*time.Second, 3*time.Second))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error:", err)
+	}
+	defer cc.Close()
+
+	if cc.conf.Addrs[0] != "cache1:127.0.0.1:6380" || cc.conf.Pool.PoolSize != 8 ||
+		cc.conf.Pool.IdleTimeout != Duration(time.Minute) || cc.conf.ConnTimeout.ReadTimeout != Duration(3*time.Second) {
+		t.Error("options are not applied", cc.conf)
+	}
+
+	if cfg.Addrs[0] != "127.0.0.1:6379" {
+		t.Error("options should not change the caller config", cfg)
+	}
+}
@@ -0,0 +1,225 @@
+package cacheclient
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// probeTimeout bound the dial and the ping of a probe, so shards that are
+// down do not hold a heartbeat for the ring timeouts
+const probeTimeout = 200 * time.Millisecond
+
+// generation is one backend built from one version of the config. Requests
+// hold it while running, so Reload can close the old one once they are done.
+type generation struct {
+	backend
+	locate shardLocator
+	wg     sync.WaitGroup
+
+	// probes ping the ring shards that the ring no longer uses
+	probeOpt *redis.Options
+	probeMu  sync.Mutex
+	probes   map[string]*redis.Client
+}
+
+func newGeneration(c *Config) (*generation, error) {
+	client, err := newBackend(c)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generation{backend: client, locate: newShardLocator(c, client)}
+	if ring, ok := client.(*redis.Ring); ok {
+		opt := ring.Options()
+		g.probeOpt = &redis.Options{
+			DB:           opt.DB,
+			Password:     opt.Password,
+			DialTimeout:  probeTimeout,
+			ReadTimeout:  probeTimeout,
+			WriteTimeout: probeTimeout,
+			PoolSize:     1,
+		}
+	}
+	return g, nil
+}
+
+// probe return a client of one connection to the ring shard at addr
+func (g *generation) probe(addr string) *redis.Client {
+	g.probeMu.Lock()
+	defer g.probeMu.Unlock()
+
+	p, ok := g.probes[addr]
+	if !ok {
+		opt := *g.probeOpt
+		opt.Addr = addr
+		p = redis.NewClient(&opt)
+		if g.probes == nil {
+			g.probes = make(map[string]*redis.Client)
+		}
+		g.probes[addr] = p
+	}
+	return p
+}
+
+// Close close the probes and the backend
+func (g *generation) Close() error {
+	g.probeMu.Lock()
+	for _, p := range g.probes {
+		p.Close()
+	}
+	g.probes = nil
+	g.probeMu.Unlock()
+
+	return g.backend.Close()
+}
+
+// acquire return the current backend, the caller must release it
+func (cc *CacheClient) acquire() *generation {
+	cc.mu.RLock()
+	g := cc.client
+	g.wg.Add(1)
+	cc.mu.RUnlock()
+	return g
+}
+
+func (g *generation) release() {
+	g.wg.Done()
+}
+
+// config return a copy of the current config
+func (cc *CacheClient) config() Config {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.conf
+}
+
+// Reload switch the client to cfg without a restart. Shards are added or
+// removed and pool and timeout settings are changed by building a new
+// backend, the old one is closed after its in-flight requests are done.
+func (cc *CacheClient) Reload(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	old := cc.config()
+	if reflect.DeepEqual(old, cfg) {
+		return nil
+	}
+
+	g, err := newGeneration(&cfg)
+	if err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	select {
+	case <-cc.done:
+		cc.mu.Unlock()
+		return g.Close()
+	default:
+	}
+	prev := cc.client
+	cc.client = g
+	cc.conf = cfg
+	if cfg.NearCache != old.NearCache {
+		cc.near = newNearCache(cfg.NearCache)
+	}
+	if !reflect.DeepEqual(cfg.HotKeys, old.HotKeys) {
+		cc.hot = newHotKeys(cfg.HotKeys)
+		cc.replicas.setKeys(cfg.HotKeys.Keys)
+	}
+	cc.mu.Unlock()
+
+	// the channel may be on another shard or no longer set
+	cc.resubscribe()
+	cc.startInvalidation()
+	cc.startReporter()
+
+	added, removed := diffAddrs(old.Addrs, cfg.Addrs)
+	log.Printf("cache: config reloaded, shards added=%v removed=%v", added, removed)
+
+	go func() {
+		prev.wg.Wait()
+		if err := prev.Close(); err != nil {
+			log.Printf("cache: close old backend failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// WatchConfig reload the client when the file at path is changed, it is
+// checked every interval until Close
+func (cc *CacheClient) WatchConfig(path string, interval time.Duration) {
+	if path == "" {
+		path = defaultConfPath
+	}
+
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cc.done:
+				return
+			case <-ticker.C:
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				log.Printf("cache: watch config %s failed: %s", path, err)
+				continue
+			}
+			if fi.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = fi.ModTime()
+
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				log.Printf("cache: reload config %s failed, keep the old one: %s", path, err)
+				continue
+			}
+			if err = cc.Reload(cfg); err != nil {
+				log.Printf("cache: reload config %s failed, keep the old one: %s", path, err)
+			}
+		}
+	}()
+}
+
+// diffAddrs return the Addrs entries only in next and only in prev
+func diffAddrs(prev, next []string) (added, removed []string) {
+	in := func(addrs []string, addr string) bool {
+		for _, a := range addrs {
+			if a == addr {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, addr := range next {
+		if !in(prev, addr) {
+			added = append(added, addr)
+		}
+	}
+	for _, addr := range prev {
+		if !in(next, addr) {
+			removed = append(removed, addr)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
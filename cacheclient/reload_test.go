@@ -0,0 +1,56 @@
+package cacheclient
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Reload(t *testing.T) {
+	cfg, err := LoadConfig("redis.json")
+	if err != nil {
+		t.Fatal("LoadConfig error", err)
+	}
+	cc, err := NewCacheClientWithConfig(cfg)
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	old := cc.acquire()
+
+	cfg.Addrs = []string{"server1:127.0.0.1:6379", "server4:127.0.0.1:6382"}
+	if err = cc.Reload(cfg); err != nil {
+		t.Fatal("Reload error", err)
+	}
+	if cc.client == old || len(cc.config().Addrs) != 2 {
+		t.Error("Reload should switch backend and config")
+	}
+
+	// old backend is closed only after the in-flight request is released
+	time.Sleep(100 * time.Millisecond)
+	if err = old.Ping().Err(); err != nil && err.Error() == "redis: client is closed" {
+		t.Error("old backend closed before release")
+	}
+	old.release()
+	time.Sleep(100 * time.Millisecond)
+	if err = old.Ping().Err(); err == nil || err.Error() != "redis: client is closed" {
+		t.Error("old backend should be closed", err)
+	}
+
+	cfg.Pool.PoolSize = -1
+	if err = cc.Reload(cfg); err == nil {
+		t.Error("Reload should reject invalid config")
+	}
+}
+
+func Test_diffAddrs(t *testing.T) {
+	added, removed := diffAddrs([]string{"server1:127.0.0.1:6379", "server2:127.0.0.1:6380"},
+		[]string{"server1:127.0.0.1:6379", "server3:127.0.0.1:6381"})
+
+	if len(added) != 1 || added[0] != "server3:127.0.0.1:6381" {
+		t.Error("diffAddrs added error", added)
+	}
+	if len(removed) != 1 || removed[0] != "server2:127.0.0.1:6380" {
+		t.Error("diffAddrs removed error", removed)
+	}
+}
@@ -0,0 +1,290 @@
+package cacheclient
+
+import (
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// A hot key is copied to Config.HotKeys.Replicas other shards under the
+// keys "key#r1", "key#r2"... and its reads are spread over the key and the
+// copies. Writes of a key that is hot or was copied by this client update
+// all its copies after the key itself, other writes touch the key only. A
+// client that does not know the key is hot, as it is not hot in its own
+// traffic, leaves the copies of other clients stale until ReplicaTTL. Keys
+// with a hash tag are not copied, they must stay on one shard.
+
+// copySearch is how many derived keys per copy are tried to find a shard
+// not used by the key or other copies yet
+const copySearch = 8
+
+// replicas is the keys marked hot and the keys copied recently
+type replicas struct {
+	mu     sync.Mutex
+	marked map[string]bool
+	// copied is when the copies of a key expire at the latest
+	copied map[string]time.Time
+	pruned time.Time
+}
+
+func (r *replicas) setKeys(keys []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.marked = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		r.marked[key] = true
+	}
+}
+
+// MarkHotKeys replicate keys from now on, with Config.HotKeys.Replicas set
+func (cc *CacheClient) MarkHotKeys(keys ...string) {
+	cc.replicas.mu.Lock()
+	defer cc.replicas.mu.Unlock()
+
+	if cc.replicas.marked == nil {
+		cc.replicas.marked = make(map[string]bool)
+	}
+	for _, key := range keys {
+		cc.replicas.marked[key] = true
+	}
+}
+
+// UnmarkHotKeys stop replicating keys marked by MarkHotKeys or the config,
+// their copies expire in ReplicaTTL
+func (cc *CacheClient) UnmarkHotKeys(keys ...string) {
+	cc.replicas.mu.Lock()
+	defer cc.replicas.mu.Unlock()
+
+	for _, key := range keys {
+		delete(cc.replicas.marked, key)
+	}
+}
+
+// isHot report whether key is marked or detected hot
+func (cc *CacheClient) isHot(key string) bool {
+	cc.replicas.mu.Lock()
+	marked := cc.replicas.marked[key]
+	cc.replicas.mu.Unlock()
+	if marked {
+		return true
+	}
+
+	hk := cc.hotKeyDetector()
+	return hk != nil && hk.isHot(key)
+}
+
+// readCopies return the copies of key to read from, nil when key is not hot
+func (cc *CacheClient) readCopies(client *generation, key string) []string {
+	conf := cc.config().HotKeys
+	if conf.Replicas == 0 || !cc.isHot(key) {
+		return nil
+	}
+	return copyKeys(client, key, conf.Replicas)
+}
+
+// writeCopies return the copies of key to update, they are the copies of a
+// hot key or of a key copied within ReplicaTTL
+func (cc *CacheClient) writeCopies(client *generation, key string) []string {
+	conf := cc.config().HotKeys
+	if conf.Replicas == 0 {
+		return nil
+	}
+	if !cc.isHot(key) {
+		cc.replicas.mu.Lock()
+		expire, ok := cc.replicas.copied[key]
+		cc.replicas.mu.Unlock()
+		if !ok || time.Now().After(expire) {
+			return nil
+		}
+	}
+	return copyKeys(client, key, conf.Replicas)
+}
+
+// copyKeys return up to n derived keys of key, each on a shard other than
+// the one of key and of the other copies
+func copyKeys(client *generation, key string, n int) []string {
+	if hashTag(key) != key {
+		return nil
+	}
+
+	used := map[string]bool{client.locate(key): true}
+	copies := make([]string, 0, n)
+	for i := 1; len(copies) < n && i <= n*copySearch; i++ {
+		copyKey := key + "#r" + strconv.Itoa(i)
+		shard := client.locate(copyKey)
+		if used[shard] {
+			continue
+		}
+		used[shard] = true
+		copies = append(copies, copyKey)
+	}
+	return copies
+}
+
+// copyTTL return ttl capped at ReplicaTTL, 0 means no expire
+func (cc *CacheClient) copyTTL(ttl time.Duration) time.Duration {
+	conf := cc.config().HotKeys
+	max := time.Duration(conf.ReplicaTTL)
+	if max == 0 {
+		max = time.Duration(conf.Window)
+	}
+	if max == 0 {
+		max = defaultHotKeysWindow
+	}
+	if ttl <= 0 || ttl > max {
+		return max
+	}
+	return ttl
+}
+
+// noteCopied remember that copies of key live until ttl later
+func (cc *CacheClient) noteCopied(key string, ttl time.Duration) {
+	now := time.Now()
+
+	cc.replicas.mu.Lock()
+	defer cc.replicas.mu.Unlock()
+
+	if cc.replicas.copied == nil {
+		cc.replicas.copied = make(map[string]time.Time)
+	}
+	if expire := now.Add(ttl); expire.After(cc.replicas.copied[key]) {
+		cc.replicas.copied[key] = expire
+	}
+
+	// drop expired entries once in a while
+	if now.Sub(cc.replicas.pruned) < ttl {
+		return
+	}
+	cc.replicas.pruned = now
+	for k, expire := range cc.replicas.copied {
+		if now.After(expire) {
+			delete(cc.replicas.copied, k)
+		}
+	}
+}
+
+// get read key, from a random copy when the key is hot and the breaker of
+// the copy shard lets it through, and return the shard that served it. A
+// copy that can not be read is counted against its shard, then falls back
+// to the key and is written again from it.
+func (cc *CacheClient) get(client *generation, key, shard string) (*redis.StringCmd, string) {
+	copies := cc.readCopies(client, key)
+	i := rand.Intn(len(copies) + 1)
+	if i == 0 {
+		return client.Get(key), shard
+	}
+
+	copyShard := client.locate(copies[i-1])
+	if cc.allow(copyShard) != nil {
+		return client.Get(key), shard
+	}
+	start := time.Now()
+	b := client.Get(copies[i-1])
+	if b.Err() == nil {
+		return b, copyShard
+	}
+	cc.countShard(copyShard, time.Since(start), b.Err())
+	return cc.fillCopies(client, map[string]string{key: copies[i-1]})[key], shard
+}
+
+// fillCopies read the keys of copies, a map of key to copy, and write each
+// value found to its copy. It returns the values of the keys.
+//
+// A write of the key may run between the read and the copy, so the copy is
+// added with SET NX and the key is read again: the copy is deleted when the
+// key changed. As writes update the copies after the key, a write either
+// sees the copy or is seen by the second read.
+func (cc *CacheClient) fillCopies(client *generation, copies map[string]string) map[string]*redis.StringCmd {
+	pipe := client.Pipeline()
+	gets := make(map[string]*redis.StringCmd, len(copies))
+	ttls := make(map[string]*redis.DurationCmd, len(copies))
+	for key := range copies {
+		gets[key] = pipe.Get(key)
+		ttls[key] = pipe.PTTL(key)
+	}
+	pipe.Exec()
+
+	pipe = client.Pipeline()
+	added := make(map[string]*redis.BoolCmd)
+	for key, copyKey := range copies {
+		if gets[key].Err() != nil || ttls[key].Err() != nil {
+			continue
+		}
+		// PTTL is negative for a key without expire
+		ttl := cc.copyTTL(ttls[key].Val())
+		added[key] = pipe.SetNX(copyKey, gets[key].Val(), ttl)
+		cc.noteCopied(key, ttl)
+	}
+	if len(added) == 0 {
+		return gets
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Printf("cache: fill %d hot key copies failed: %s", len(added), err)
+	}
+
+	pipe = client.Pipeline()
+	checks := make(map[string]*redis.StringCmd)
+	for key, cmd := range added {
+		if cmd.Val() {
+			checks[key] = pipe.Get(key)
+		}
+	}
+	if len(checks) == 0 {
+		return gets
+	}
+	pipe.Exec()
+
+	pipe = client.Pipeline()
+	var stale int
+	for key, cmd := range checks {
+		if cmd.Err() != nil || cmd.Val() != gets[key].Val() {
+			pipe.Del(copies[key])
+			stale++
+		}
+	}
+	if stale > 0 {
+		if _, err := pipe.Exec(); err != nil {
+			log.Printf("cache: drop %d stale hot key copies failed: %s", stale, err)
+		}
+	}
+	return gets
+}
+
+// updateCopies add to pipe the writes of the copies of key from
+// writeCopies, to run after the key is written. The copies are set to
+// value, or deleted when value is nil as for Del or a failed write. It
+// returns the number of commands added.
+func (cc *CacheClient) updateCopies(pipe redis.Pipeliner, client *generation, key string, value interface{}, ttl time.Duration) int {
+	copies := cc.writeCopies(client, key)
+	if len(copies) == 0 {
+		return 0
+	}
+	if value == nil {
+		for _, copyKey := range copies {
+			pipe.Del(copyKey)
+		}
+		return len(copies)
+	}
+
+	ttl = cc.copyTTL(ttl)
+	for _, copyKey := range copies {
+		pipe.Set(copyKey, value, ttl)
+	}
+	cc.noteCopied(key, ttl)
+	return len(copies)
+}
+
+// execCopies run pipe of n copy writes from updateCopies
+func execCopies(pipe redis.Pipeliner, n int) {
+	if n == 0 {
+		return
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Printf("cache: update %d hot key copies failed: %s", n, err)
+	}
+}
@@ -0,0 +1,221 @@
+package cacheclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_copyKeys(t *testing.T) {
+	client := &generation{locate: newShardLocator(&Config{
+		Addrs: []string{"shard1:127.0.0.1:1", "shard2:127.0.0.1:2", "shard3:127.0.0.1:3"},
+	}, nil)}
+
+	copies := copyKeys(client, "item:42", 2)
+	if len(copies) != 2 {
+		t.Fatal("copyKeys should return 2 copies", copies)
+	}
+	shards := map[string]bool{client.locate("item:42"): true}
+	for _, copyKey := range copies {
+		if shards[client.locate(copyKey)] {
+			t.Error("copies should be on different shards", copies)
+		}
+		shards[client.locate(copyKey)] = true
+	}
+
+	// only 2 other shards
+	if copies := copyKeys(client, "item:42", 5); len(copies) != 2 {
+		t.Error("copies should not share shards", copies)
+	}
+	if copies := copyKeys(client, "{user}.item", 2); copies != nil {
+		t.Error("keys with hash tag should not be copied", copies)
+	}
+}
+
+func Test_replicas(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHotKeyReplicas(2, 0, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	client := cc.acquire()
+	defer client.release()
+
+	if cc.readCopies(client, "item:42") != nil {
+		t.Error("keys should not be hot by default")
+	}
+
+	cc.MarkHotKeys("item:42")
+	if len(cc.readCopies(client, "item:42")) == 0 {
+		t.Error("marked key should be read from copies")
+	}
+
+	cc.noteCopied("item:42", cc.copyTTL(time.Hour))
+	cc.UnmarkHotKeys("item:42")
+	if cc.readCopies(client, "item:42") != nil {
+		t.Error("unmarked key should be read from the key only")
+	}
+	// copies may be alive, writes still update them
+	if len(cc.writeCopies(client, "item:42")) == 0 {
+		t.Error("copies within ReplicaTTL should be written")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if cc.writeCopies(client, "item:42") != nil {
+		t.Error("copies after ReplicaTTL should not be written")
+	}
+}
+
+func Test_replicas_Writes(t *testing.T) {
+	srv := newFakeRedis(t)
+	defer srv.Close()
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeRing),
+		WithAddrs("shard1:"+srv.Addr(), "shard2:"+srv.Addr(), "shard3:"+srv.Addr()),
+		WithHotKeyReplicas(2, 0, time.Minute))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	client := cc.acquire()
+	defer client.release()
+	copies := copyKeys(client, "item:42", 2)
+	if len(copies) != 2 {
+		t.Fatal("copyKeys should return 2 copies", copies)
+	}
+	copyValues := func() []string {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		var values []string
+		for _, copyKey := range copies {
+			if v, ok := srv.data[copyKey]; ok {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+
+	// writes of keys that are not hot touch the key only
+	var cmds int
+	srv.mu.Lock()
+	srv.after = func(args []string) {
+		if len(args) > 1 && strings.Contains(args[1], "#r") {
+			cmds++
+		}
+	}
+	srv.mu.Unlock()
+	if err := cc.Set("item:42", "new", 60); err != nil {
+		t.Fatal("Set error", err)
+	}
+	if _, err := cc.Del("item:7"); err != nil {
+		t.Fatal("Del error", err)
+	}
+	srv.mu.Lock()
+	srv.after = nil
+	srv.mu.Unlock()
+	if cmds != 0 {
+		t.Error("writes of keys that are not hot should not write copies", cmds)
+	}
+
+	cc.MarkHotKeys("item:42")
+	if err := cc.Set("item:42", "hot", 60); err != nil {
+		t.Fatal("Set error", err)
+	}
+	if values := copyValues(); len(values) != 2 || values[0] != "hot" || values[1] != "hot" {
+		t.Error("Set of a hot key should write the copies", values)
+	}
+	cc.UnmarkHotKeys("item:42")
+	if _, err := cc.Del("item:42"); err != nil {
+		t.Fatal("Del error", err)
+	}
+	if values := copyValues(); len(values) != 0 {
+		t.Error("Del should delete the copies", values)
+	}
+
+	// a Set of another client between the read and the copy of the key
+	srv.mu.Lock()
+	srv.data["item:42"] = "v1"
+	srv.after = func(args []string) {
+		if strings.ToLower(args[0]) == "pttl" {
+			srv.data["item:42"] = "v2"
+			delete(srv.data, copies[0])
+			srv.after = nil
+		}
+	}
+	srv.mu.Unlock()
+	gets := cc.fillCopies(client, map[string]string{"item:42": copies[0]})
+	if gets["item:42"].Val() != "v1" {
+		t.Error("fillCopies should return the value read", gets["item:42"].Val())
+	}
+	if values := copyValues(); len(values) != 0 {
+		t.Error("fillCopies should not keep a value older than the key", values)
+	}
+
+	// the copy is kept when the key did not change
+	gets = cc.fillCopies(client, map[string]string{"item:42": copies[0]})
+	if values := copyValues(); len(values) != 1 || values[0] != "v2" {
+		t.Error("fillCopies should copy the key", values)
+	}
+}
+
+// reads of a copy are counted against the shard of the copy, a copy shard
+// that fails opens its own breaker, not the one of the key
+func Test_replicas_CopyShardBreaker(t *testing.T) {
+	srv := newFakeRedis(t)
+	defer srv.Close()
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeRing),
+		WithAddrs("shard1:"+srv.Addr(), "shard2:"+srv.Addr(), "shard3:"+srv.Addr()),
+		WithHotKeyReplicas(2, 0, time.Minute),
+		WithBreaker(time.Minute, 1, 0.5, time.Minute))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	client := cc.acquire()
+	defer client.release()
+	cc.MarkHotKeys("item:42")
+	if err := cc.Set("item:42", "v1", 60); err != nil {
+		t.Fatal("Set error", err)
+	}
+	copies := copyKeys(client, "item:42", 2)
+	srv.fail(copies...)
+
+	for i := 0; i < 20; i++ {
+		if v, err := cc.GetString("item:42"); err != nil || v != "v1" {
+			t.Fatal("Get should fall back to the key", v, err)
+		}
+		res, err := cc.Gets([]string{"item:42"})
+		if err != nil || res["item:42"].Val() != "v1" {
+			t.Fatal("Gets should fall back to the key", err)
+		}
+	}
+	if b, _ := cc.shardBreaker(client.locate("item:42")); b.current() != breakerClosed {
+		t.Error("breaker of the key shard should stay closed", b.current())
+	}
+	for _, copyKey := range copies {
+		if b, _ := cc.shardBreaker(client.locate(copyKey)); b.current() != breakerOpen {
+			t.Error("breaker of a failing copy shard should open", copyKey, b.current())
+		}
+	}
+}
+
+func Test_hotKeys_isHot(t *testing.T) {
+	hk := newHotKeys(HotKeysConfig{Size: 10, Window: Duration(50 * time.Millisecond), ReplicaQPS: 100})
+	for i := 0; i < 100; i++ {
+		hk.record("key1")
+	}
+	hk.record("key2")
+
+	time.Sleep(60 * time.Millisecond)
+	hk.top(1)
+	if !hk.isHot("key1") {
+		t.Error("key1 should be hot")
+	}
+	if hk.isHot("key2") {
+		t.Error("key2 should not be hot")
+	}
+}
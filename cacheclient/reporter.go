@@ -0,0 +1,274 @@
+package cacheclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The reporter sends the stats of every Config.Stats.Interval to the sinks
+// of Config.Stats and the ones added by AddStatsSink. The stats are written
+// to the log when there is no other sink.
+
+// StatsSink receive the stats of each reporting interval
+type StatsSink interface {
+	Report(st Stats) error
+}
+
+// StatsSinkFunc is a StatsSink calling the func
+type StatsSinkFunc func(st Stats) error
+
+// Report call f
+func (f StatsSinkFunc) Report(st Stats) error {
+	return f(st)
+}
+
+// LogSink write the stats to the log as JSON
+type LogSink struct{}
+
+// Report log st
+func (LogSink) Report(st Stats) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	log.Printf("cache: stats %s", b)
+	return nil
+}
+
+// statsdPacketSize keeps a StatsD packet in one Ethernet frame
+const statsdPacketSize = 1432
+
+// StatsDSink send the stats to a StatsD server over UDP, counters of the
+// interval as |c, ratios and latencies as |g. Latencies are in µs.
+type StatsDSink struct {
+	Addr string
+	// Prefix of the metric names, the default is "cacheclient"
+	Prefix string
+}
+
+// Report send st in packets of at most statsdPacketSize bytes
+func (s StatsDSink) Report(st Stats) error {
+	conn, err := net.Dial("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	for _, line := range statsdLines(s.Prefix, st) {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdPacketSize {
+			if _, err = conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		_, err = conn.Write(buf.Bytes())
+	}
+	return err
+}
+
+func statsdLines(prefix string, st Stats) []string {
+	if prefix == "" {
+		prefix = "cacheclient"
+	}
+
+	var lines []string
+	add := func(name string, v interface{}, typ string) {
+		lines = append(lines, fmt.Sprintf("%s.%s:%v|%s", prefix, name, v, typ))
+	}
+	latency := func(name string, l Latency) {
+		add(name+".p50", l.P50, "g")
+		add(name+".p90", l.P90, "g")
+		add(name+".p99", l.P99, "g")
+		add(name+".p999", l.P999, "g")
+	}
+
+	add("requests", st.Requests, "c")
+	add("errors", st.Errors, "c")
+	add("timeouts", st.Timeouts, "c")
+	add("hits", st.Hits, "c")
+	add("misses", st.Misses, "c")
+	add("stale", st.Stale, "c")
+	add("early_refreshes", st.EarlyRefreshes, "c")
+	add("tombstone_hits", st.TombstoneHits, "c")
+	add("hit_ratio", st.HitRatio, "g")
+	add("qps", st.QPS, "g")
+	latency("latency", st.Latency)
+
+	ops := make([]string, 0, len(st.Ops))
+	for name := range st.Ops {
+		ops = append(ops, name)
+	}
+	sort.Strings(ops)
+	for _, name := range ops {
+		o := st.Ops[name]
+		name = "op." + statsdName(name)
+		add(name+".calls", o.Calls, "c")
+		add(name+".errors", o.Errors, "c")
+		latency(name+".latency", o.Latency)
+	}
+
+	shards := make([]string, 0, len(st.Shards))
+	for name := range st.Shards {
+		shards = append(shards, name)
+	}
+	sort.Strings(shards)
+	for _, name := range shards {
+		s := st.Shards[name]
+		name = "shard." + statsdName(name)
+		add(name+".calls", s.Calls, "c")
+		add(name+".errors", s.Errors, "c")
+		add(name+".rejected", s.Rejected, "c")
+		latency(name+".latency", s.Latency)
+	}
+	return lines
+}
+
+// statsdName replace the characters with a meaning in StatsD
+func statsdName(name string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_").Replace(name)
+}
+
+// httpSinkTimeout bound a POST, Close waits for the last one
+const httpSinkTimeout = 5 * time.Second
+
+// HTTPSink POST the stats as JSON to URL
+type HTTPSink struct {
+	URL string
+}
+
+var httpSinkClient = &http.Client{Timeout: httpSinkTimeout}
+
+// Report post st, a status other than 2xx is an error
+func (s HTTPSink) Report(st Stats) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpSinkClient.Post(s.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("cache: post stats to %s: %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// reporter is the stats reporting of a client
+type reporter struct {
+	start sync.Once
+	wg    sync.WaitGroup
+
+	mu    sync.Mutex
+	sinks []StatsSink
+}
+
+// AddStatsSink add a sink of the stats reported every Config.Stats.Interval
+func (cc *CacheClient) AddStatsSink(sink StatsSink) {
+	cc.reporter.mu.Lock()
+	cc.reporter.sinks = append(cc.reporter.sinks, sink)
+	cc.reporter.mu.Unlock()
+}
+
+// statsSinks return the sinks of c and the added ones, LogSink when there
+// is none
+func (cc *CacheClient) statsSinks(c StatsConfig) []StatsSink {
+	var sinks []StatsSink
+	if c.Log {
+		sinks = append(sinks, LogSink{})
+	}
+	if c.StatsD != "" {
+		sinks = append(sinks, StatsDSink{Addr: c.StatsD, Prefix: c.Prefix})
+	}
+	if c.URL != "" {
+		sinks = append(sinks, HTTPSink{URL: c.URL})
+	}
+
+	cc.reporter.mu.Lock()
+	sinks = append(sinks, cc.reporter.sinks...)
+	cc.reporter.mu.Unlock()
+
+	if len(sinks) == 0 {
+		sinks = append(sinks, LogSink{})
+	}
+	return sinks
+}
+
+// startReporter start the reporting loop once Stats.Interval is set
+func (cc *CacheClient) startReporter() {
+	if cc.config().Stats.Interval <= 0 {
+		return
+	}
+	cc.reporter.start.Do(func() {
+		select {
+		case <-cc.done:
+			return
+		default:
+		}
+		cc.reporter.wg.Add(1)
+		go cc.report()
+	})
+}
+
+// report send the stats every interval until Close, the stats since the
+// last report are sent on Close
+func (cc *CacheClient) report() {
+	defer cc.reporter.wg.Done()
+
+	w := cc.NewStatsWindow()
+	for {
+		conf := cc.config().Stats
+		interval := time.Duration(conf.Interval)
+		if interval <= 0 {
+			// disabled by Reload, check again later
+			interval = time.Second
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-cc.done:
+			timer.Stop()
+			if conf.Interval > 0 {
+				cc.sendStats(conf, w.Next())
+			}
+			return
+		case <-timer.C:
+		}
+
+		// the window moves on while reporting is disabled
+		st := w.Next()
+		if conf.Interval > 0 {
+			cc.sendStats(conf, st)
+		}
+	}
+}
+
+func (cc *CacheClient) sendStats(conf StatsConfig, st Stats) {
+	for _, sink := range cc.statsSinks(conf) {
+		if err := sink.Report(st); err != nil {
+			log.Printf("cache: report stats to %T failed: %s", sink, err)
+		}
+	}
+}
+
+// stopReporter wait for the last report after done is closed
+func (cc *CacheClient) stopReporter() {
+	cc.reporter.wg.Wait()
+}
@@ -0,0 +1,110 @@
+package cacheclient
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_statsdLines(t *testing.T) {
+	st := Stats{
+		Requests: 3,
+		Ops:      map[string]OpStats{"Get": {Calls: 3, Latency: Latency{P99: 250}}},
+		Shards:   map[string]ShardStats{"10.0.0.1:6379": {Calls: 3}},
+	}
+	lines := strings.Join(statsdLines("", st), "\n")
+	for _, line := range []string{
+		"cacheclient.requests:3|c",
+		"cacheclient.op.Get.latency.p99:250|g",
+		"cacheclient.shard.10_0_0_1_6379.calls:3|c",
+	} {
+		if !strings.Contains(lines, line) {
+			t.Error("statsd lines should contain", line)
+		}
+	}
+}
+
+func Test_StatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("ListenPacket error", err)
+	}
+	defer conn.Close()
+
+	sink := StatsDSink{Addr: conn.LocalAddr().String(), Prefix: "app.cache"}
+	if err = sink.Report(Stats{Requests: 7}); err != nil {
+		t.Fatal("Report error", err)
+	}
+
+	buf := make([]byte, statsdPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil || !strings.HasPrefix(string(buf[:n]), "app.cache.requests:7|c\n") {
+		t.Error("StatsD packet error", string(buf[:n]), err)
+	}
+}
+
+func Test_HTTPSink(t *testing.T) {
+	var got Stats
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = Stats{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil || got.Requests == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	if err := (HTTPSink{URL: srv.URL}).Report(Stats{Requests: 7}); err != nil || got.Requests != 7 {
+		t.Error("HTTPSink error", got, err)
+	}
+	if err := (HTTPSink{URL: srv.URL}).Report(Stats{}); err == nil {
+		t.Error("HTTPSink should fail on status 400")
+	}
+}
+
+// server is down, stats are reported every interval and once more on Close
+func Test_reporter(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithStatsInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+
+	var mu sync.Mutex
+	var reports []Stats
+	cc.AddStatsSink(StatsSinkFunc(func(st Stats) error {
+		mu.Lock()
+		reports = append(reports, st)
+		mu.Unlock()
+		return nil
+	}))
+
+	cc.Get("key1")
+	time.Sleep(50 * time.Millisecond)
+	cc.Get("key1")
+	cc.Close()
+
+	mu.Lock()
+	n := len(reports)
+	var requests int64
+	for _, st := range reports {
+		requests += st.Requests
+	}
+	mu.Unlock()
+	if n < 2 || requests != 2 {
+		t.Error("reports error", n, requests)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if len(reports) != n {
+		t.Error("reporter should stop on Close")
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,322 @@
+package cacheclient
+
+import (
+	"hash/crc32"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// go-redis keeps its key routing internal, shardLocator repeats it so keys
+// can be reported with the shard that serves them
+
+// ringReplicas is the number of points of a shard on the go-redis ring
+const ringReplicas = 100
+
+// clusterSlots is the number of Redis Cluster hash slots
+const clusterSlots = 16384
+
+// clusterSlotsRefresh is how often the masters of the cluster slots are
+// loaded again
+const clusterSlotsRefresh = time.Minute
+
+// shardLocator return the shard of a key: the ring shard name, the cluster
+// master address, the sentinel master name or the single node address
+type shardLocator func(key string) string
+
+// newShardLocator return the locator of client built from c. The ring
+// locator uses the shards the ring keeps, the keys of a shard that is down
+// are located on the shards they are remapped to. Without a ring client it
+// uses all shards of c. The cluster locator returns "" until the slots are
+// loaded from client.
+func newShardLocator(c *Config, client backend) shardLocator {
+	switch c.HashType {
+	case "", HashTypeRing:
+		addrs := make(map[string]string)
+		parseStringsToMap(c.Addrs, addrs)
+		ring, ok := client.(*redis.Ring)
+		if !ok {
+			names := make([]string, 0, len(addrs))
+			for name := range addrs {
+				names = append(names, name)
+			}
+			return newRingHash(names).get
+		}
+		l := &ringLocator{ring: ring, names: make(map[string][]string)}
+		for name, addr := range addrs {
+			l.names[addr] = append(l.names[addr], name)
+		}
+		return l.get
+
+	case HashTypeCluster:
+		cluster, ok := client.(*redis.ClusterClient)
+		if !ok {
+			return func(string) string { return "" }
+		}
+		return (&clusterLocator{client: cluster}).get
+
+	case HashTypeSentinel:
+		master := c.MasterName
+		return func(string) string { return master }
+	}
+
+	var addr string
+	if addrs := hostPorts(c.Addrs); len(addrs) > 0 {
+		addr = addrs[0]
+	}
+	return func(string) string { return addr }
+}
+
+// ringHash is the consistent hash of the go-redis ring
+type ringHash struct {
+	points []int
+	names  map[int]string
+}
+
+func newRingHash(names []string) *ringHash {
+	h := &ringHash{names: make(map[int]string)}
+	// points of two shards rarely collide, sorting makes the result of
+	// a collision stable at least
+	sort.Strings(names)
+	for _, name := range names {
+		for i := 0; i < ringReplicas; i++ {
+			point := int(crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + name)))
+			h.points = append(h.points, point)
+			h.names[point] = name
+		}
+	}
+	sort.Ints(h.points)
+	return h
+}
+
+func (h *ringHash) get(key string) string {
+	if len(h.points) == 0 {
+		return ""
+	}
+
+	point := int(crc32.ChecksumIEEE([]byte(hashTag(key))))
+	i := sort.SearchInts(h.points, point)
+	if i == len(h.points) {
+		i = 0
+	}
+	return h.names[h.points[i]]
+}
+
+// ringLocator map keys to the shards the ring keeps. The ring drops and
+// adds back shards at its heartbeat, the live shards are read again once
+// per heartbeat.
+type ringLocator struct {
+	ring *redis.Ring
+	// names of the shards at each address
+	names map[string][]string
+
+	mu     sync.Mutex
+	hash   *ringHash
+	live   string
+	loaded time.Time
+}
+
+func (l *ringLocator) get(key string) string {
+	l.mu.Lock()
+	if time.Since(l.loaded) >= l.ring.Options().HeartbeatFrequency {
+		l.load()
+	}
+	h := l.hash
+	l.mu.Unlock()
+	return h.get(key)
+}
+
+// load read the live shards of the ring and rebuild the hash when they
+// changed, l.mu is held
+func (l *ringLocator) load() {
+	var mu sync.Mutex
+	addrs := make(map[string]bool)
+	l.ring.ForEachShard(func(shard *redis.Client) error {
+		mu.Lock()
+		addrs[shard.Options().Addr] = true
+		mu.Unlock()
+		return nil
+	})
+	// shards may share an address, each name is added once
+	var names []string
+	for addr := range addrs {
+		names = append(names, l.names[addr]...)
+	}
+	sort.Strings(names)
+	if live := strings.Join(names, ","); l.hash == nil || live != l.live {
+		l.hash, l.live = newRingHash(names), live
+	}
+	l.loaded = time.Now()
+}
+
+// clusterLocator map keys to the master of their slot, the masters are
+// loaded by CLUSTER SLOTS in the background
+type clusterLocator struct {
+	client  *redis.ClusterClient
+	masters atomic.Value // []string of clusterSlots
+
+	mu      sync.Mutex
+	loaded  time.Time
+	loading bool
+}
+
+func (l *clusterLocator) get(key string) string {
+	l.mu.Lock()
+	if !l.loading && time.Since(l.loaded) > clusterSlotsRefresh {
+		l.loading = true
+		go l.load()
+	}
+	l.mu.Unlock()
+
+	masters, _ := l.masters.Load().([]string)
+	if masters == nil {
+		return ""
+	}
+	return masters[keySlot(key)]
+}
+
+func (l *clusterLocator) load() {
+	slots, err := l.client.ClusterSlots().Result()
+	if err != nil {
+		log.Printf("cache: load cluster slots failed: %s", err)
+	} else {
+		l.masters.Store(slotMasters(slots))
+	}
+
+	l.mu.Lock()
+	l.loading = false
+	l.loaded = time.Now()
+	l.mu.Unlock()
+}
+
+// slotMasters return the master address of each slot, the first node of a
+// slot range is its master
+func slotMasters(slots []redis.ClusterSlot) []string {
+	masters := make([]string, clusterSlots)
+	for _, s := range slots {
+		if len(s.Nodes) == 0 || s.Start < 0 || s.End >= clusterSlots {
+			continue
+		}
+		for i := s.Start; i <= s.End; i++ {
+			masters[i] = s.Nodes[0].Addr
+		}
+	}
+	return masters
+}
+
+// hashTag return the part of key inside the first {}, keys with the same
+// tag are kept on the same shard
+func hashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+e+1]
+		}
+	}
+	return key
+}
+
+// keySlot return the cluster hash slot of key
+func keySlot(key string) int {
+	return int(crc16(hashTag(key))) % clusterSlots
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum of Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// shardState is a shard of the backend, Pool is nil for a ring shard that
+// is down. Err is the error of the ping, Up may differ for ring shards.
+type shardState struct {
+	Name string
+	Addr string
+	Up   bool
+	Err  error
+	Pool *redis.PoolStats
+}
+
+// shardStates return the shards of the backend sorted by name. Ring shards
+// are read from the ring, which pings them itself: the ones it keeps are up
+// and the ones it dropped are pinged by a probe, all at once. Other nodes
+// are pinged and up if the ping succeeds.
+func (cc *CacheClient) shardStates() []shardState {
+	client := cc.acquire()
+	defer client.release()
+
+	var mu sync.Mutex
+	var states []shardState
+	add := func(s shardState) {
+		mu.Lock()
+		states = append(states, s)
+		mu.Unlock()
+	}
+
+	switch c := client.backend.(type) {
+	case *redis.Ring:
+		addrs := make(map[string]string)
+		parseStringsToMap(cc.config().Addrs, addrs)
+		live := make(map[string]*redis.PoolStats)
+		c.ForEachShard(func(shard *redis.Client) error {
+			mu.Lock()
+			live[shard.Options().Addr] = shard.PoolStats()
+			mu.Unlock()
+			return nil
+		})
+		var wg sync.WaitGroup
+		for name, addr := range addrs {
+			if pool, up := live[addr]; up {
+				add(shardState{Name: name, Addr: addr, Up: true, Pool: pool})
+				continue
+			}
+			wg.Add(1)
+			go func(name, addr string) {
+				defer wg.Done()
+				add(shardState{Name: name, Addr: addr, Err: client.probe(addr).Ping().Err()})
+			}(name, addr)
+		}
+		wg.Wait()
+
+	case *redis.ClusterClient:
+		c.ForEachNode(func(node *redis.Client) error {
+			addr := node.Options().Addr
+			err := node.Ping().Err()
+			add(shardState{Name: addr, Addr: addr, Up: err == nil, Err: err, Pool: node.PoolStats()})
+			return nil
+		})
+
+	case *redis.Client:
+		addr := c.Options().Addr
+		if cc.config().HashType == HashTypeSentinel {
+			// the failover client does not tell the master address
+			addr = ""
+		}
+		err := c.Ping().Err()
+		add(shardState{
+			Name: client.locate(""),
+			Addr: addr,
+			Up:   err == nil,
+			Err:  err,
+			Pool: c.PoolStats(),
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
@@ -0,0 +1,132 @@
+package cacheclient
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_keySlot(t *testing.T) {
+	if crc16("123456789") != 0x31c3 {
+		t.Error("crc16 error", crc16("123456789"))
+	}
+	if keySlot("foo") != 12182 {
+		t.Error("keySlot error", keySlot("foo"))
+	}
+	if keySlot("{user1000}.following") != keySlot("user1000") {
+		t.Error("keys with the same hash tag should be in the same slot")
+	}
+}
+
+// the locator should pick the shard the ring dials, each shard of the ring
+// below refuses connections on its own port. EVAL is routed by its first
+// key without the COMMAND info of a live server.
+func Test_newShardLocator_Ring(t *testing.T) {
+	addrs := map[string]string{
+		"shard1": "127.0.0.1:1",
+		"shard2": "127.0.0.1:2",
+		"shard3": "127.0.0.1:3",
+	}
+	ring := redis.NewRing(&redis.RingOptions{
+		Addrs:              addrs,
+		HeartbeatFrequency: time.Hour,
+		MaxRetries:         0,
+	})
+	defer ring.Close()
+
+	locate := newShardLocator(&Config{
+		HashType: HashTypeRing,
+		Addrs:    []string{"shard1:127.0.0.1:1", "shard2:127.0.0.1:2", "shard3:127.0.0.1:3"},
+	}, ring)
+	for _, key := range []string{"key1", "key2", "key3", "user:42", "{tag}.a", "product_9"} {
+		err := ring.Eval("return 1", []string{key}).Err()
+		if err == nil || !strings.Contains(err.Error(), addrs[locate(key)]) {
+			t.Errorf("key %s located at %s, ring error: %v", key, locate(key), err)
+		}
+	}
+}
+
+func Test_newShardLocator(t *testing.T) {
+	locate := newShardLocator(&Config{HashType: HashTypeSentinel, MasterName: "mymaster"}, nil)
+	if locate("key1") != "mymaster" {
+		t.Error("sentinel locator error", locate("key1"))
+	}
+	locate = newShardLocator(&Config{HashType: HashTypeSingle, Addrs: []string{"127.0.0.1:6379"}}, nil)
+	if locate("key1") != "127.0.0.1:6379" {
+		t.Error("single locator error", locate("key1"))
+	}
+}
+
+func Test_slotMasters(t *testing.T) {
+	masters := slotMasters([]redis.ClusterSlot{
+		{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: "127.0.0.1:7000"}, {Addr: "127.0.0.1:7003"}}},
+		{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: "127.0.0.1:7001"}}},
+	})
+	// foo is in slot 12182
+	if masters[keySlot("foo")] != "127.0.0.1:7001" || masters[0] != "127.0.0.1:7000" {
+		t.Error("slotMasters error", masters[keySlot("foo")], masters[0])
+	}
+
+	// the locator knows no master before the slots are loaded
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}})
+	defer cluster.Close()
+	locate := newShardLocator(&Config{HashType: HashTypeCluster}, cluster)
+	if locate("foo") != "" {
+		t.Error("cluster locator error", locate("foo"))
+	}
+}
+
+// keys of a shard the ring drops are located, and let through the breaker,
+// on the shard the ring remaps them to
+func Test_newShardLocator_RingDown(t *testing.T) {
+	srv1, srv2 := newFakeRedis(t), newFakeRedis(t)
+	defer srv1.Close()
+	defer srv2.Close()
+
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeRing),
+		WithAddrs("shard1:"+srv1.Addr(), "shard2:"+srv2.Addr()),
+		WithHeartbeatFrequency(10*time.Millisecond),
+		WithBreaker(time.Minute, 1, 0.5, time.Minute))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	client := cc.acquire()
+	defer client.release()
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := "key" + strconv.Itoa(i); client.locate(k) == "shard2" {
+			key = k
+		}
+	}
+
+	srv2.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for client.locate(key) != "shard1" {
+		if time.Now().After(deadline) {
+			t.Fatal("key of a shard that is down should be remapped", client.locate(key))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the failed calls of shard2 would open its breaker
+	cc.record("shard2", 0, errors.New("down"))
+	if err := cc.Set(key, "value", 60); err != nil {
+		t.Fatal("Set of a remapped key error", err)
+	}
+	if v, err := cc.Get(key).Result(); err != nil || v != "value" {
+		t.Error("Get of a remapped key error", v, err)
+	}
+	srv1.mu.Lock()
+	_, ok := srv1.data[key]
+	srv1.mu.Unlock()
+	if !ok {
+		t.Error("remapped key should be written to shard1")
+	}
+}
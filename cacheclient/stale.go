@@ -0,0 +1,122 @@
+package cacheclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// With Config.Stale.TTL set, objects written with a ttl are kept in Redis
+// for ttl + Stale.TTL. The ttl is the soft expiry in the envelope of the
+// value, a read past it gets the stale value and GetOrLoad refreshes it in
+// background. The stale value is served until Redis expires it, whether
+// the refresh fails or not.
+
+// staleEnvelopeID is the header byte of a value with a soft expiry. Like
+// the codec ids it is below 0x20, and the encoded object follows the 8
+// bytes of the expiry.
+const staleEnvelopeID byte = 0x0f
+
+const staleHeaderLen = 9
+
+// sealStale put data, an encoded object, in an envelope expiring at soft
+func sealStale(data []byte, soft time.Time) []byte {
+	b := make([]byte, staleHeaderLen, staleHeaderLen+len(data))
+	b[0] = staleEnvelopeID
+	binary.BigEndian.PutUint64(b[1:], uint64(soft.UnixNano()))
+	return append(b, data...)
+}
+
+// openStale return the object in the envelope of data and its soft expiry,
+// data without an envelope is returned as is with a zero expiry
+func openStale(data []byte) ([]byte, time.Time) {
+	data, soft, _ := openEnvelope(data)
+	return data, soft
+}
+
+// openEnvelope return the object in the stale or XFetch envelope of data,
+// its expiry and recompute time
+func openEnvelope(data []byte) ([]byte, time.Time, time.Duration) {
+	switch {
+	case len(data) >= staleHeaderLen && data[0] == staleEnvelopeID:
+		soft := int64(binary.BigEndian.Uint64(data[1:staleHeaderLen]))
+		return data[staleHeaderLen:], time.Unix(0, soft), 0
+	case len(data) >= xfetchHeaderLen && data[0] == xfetchEnvelopeID:
+		expiry := int64(binary.BigEndian.Uint64(data[1:9]))
+		delta := int64(binary.BigEndian.Uint64(data[9:xfetchHeaderLen]))
+		return data[xfetchHeaderLen:], time.Unix(0, expiry), time.Duration(delta)
+	}
+	return data, time.Time{}, 0
+}
+
+// isStale tell if a value with the soft expiry is past it
+func isStale(soft time.Time) bool {
+	return !soft.IsZero() && time.Now().After(soft)
+}
+
+// staleWrite return the value and the Redis ttl of an encoded object. With
+// ttl set, it is in an XFetch envelope when the recompute time delta is
+// known, or in a stale envelope when serve-stale is on.
+func (cc *CacheClient) staleWrite(b []byte, ttl, delta time.Duration) ([]byte, time.Duration) {
+	if ttl <= 0 {
+		return b, ttl
+	}
+	grace := time.Duration(cc.config().Stale.TTL)
+	if grace < 0 {
+		grace = 0
+	}
+	expiry := time.Now().Add(ttl)
+
+	switch {
+	case delta > 0:
+		return sealXFetch(b, expiry, delta), ttl + grace
+	case grace > 0:
+		return sealStale(b, expiry), ttl + grace
+	}
+	return b, ttl
+}
+
+// GetObjectStale get object from cache like GetObject, stale is true when
+// the value is past its soft expiry
+func (cc *CacheClient) GetObjectStale(key string, object interface{}) (stale bool, err error) {
+	b, err := cc.Get(key).Bytes()
+	if err != nil {
+		return false, err
+	}
+	return cc.decodeCached(key, b, object)
+}
+
+// decodeCached decode b, the cached value of key, into object. It returns
+// ErrNotFoundCached for a tombstone and tells if the value is stale.
+func (cc *CacheClient) decodeCached(key string, b []byte, object interface{}) (stale bool, err error) {
+	if isTombstone(b) {
+		return false, cc.tombstoneHit()
+	}
+
+	data, soft := openStale(b)
+	if err = cc.decode(key, data, object); err != nil {
+		return false, err
+	}
+
+	if isStale(soft) {
+		atomic.AddUint64(&cc.stats.stale, 1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// refresh load key in background unless it is loading already, the stale
+// value is kept when loader fails
+func (cc *CacheClient) refresh(key string, ttl time.Duration, loader Loader, o loadOptions) {
+	cc.loads.start(key, func(ctx context.Context) ([]byte, error) {
+		b, err := cc.load(ctx, key, ttl, loader, o)
+		// on ErrNotFound the value is replaced by a tombstone
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("cache: refresh key=%q failed, serve the stale value: %s", key, err)
+		}
+		return b, err
+	})
+}
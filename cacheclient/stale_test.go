@@ -0,0 +1,74 @@
+package cacheclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_sealStale(t *testing.T) {
+	b, _ := encodeObject(JSONCodec, map[string]int{"a": 1})
+	soft := time.Unix(0, time.Now().UnixNano())
+
+	data, expiry := openStale(sealStale(b, soft))
+	if string(data) != string(b) || !expiry.Equal(soft) {
+		t.Error("openStale error", data, expiry)
+	}
+	if data, expiry = openStale(b); string(data) != string(b) || !expiry.IsZero() {
+		t.Error("openStale should keep a value without envelope", data, expiry)
+	}
+
+	var v map[string]int
+	if err := decodeObject(sealStale(b, soft), &v, 0); err != nil || v["a"] != 1 {
+		t.Error("decodeObject should drop the envelope", v, err)
+	}
+}
+
+func Test_ServeStale(t *testing.T) {
+	cc, srv := newTestClient(t, WithServeStale(time.Minute))
+
+	if err := cc.SetObjectWithTTL("key1", "v1", 50*time.Millisecond); err != nil {
+		t.Fatal("SetObjectWithTTL error", err)
+	}
+	if ttl := srv.ttl("key1"); ttl < time.Minute-time.Second {
+		t.Error("value should be kept for the stale TTL", ttl)
+	}
+
+	var v string
+	if stale, err := cc.GetObjectStale("key1", &v); err != nil || stale || v != "v1" {
+		t.Error("GetObjectStale error", stale, v, err)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if stale, err := cc.GetObjectStale("key1", &v); err != nil || !stale || v != "v1" {
+		t.Error("value should be stale", stale, v, err)
+	}
+
+	// a failed refresh keeps the stale value
+	failed := make(chan struct{})
+	stale, err := cc.GetOrLoadStale(context.Background(), "key1", time.Minute, func() (interface{}, error) {
+		close(failed)
+		return nil, errors.New("db down")
+	}, &v)
+	if err != nil || !stale || v != "v1" {
+		t.Error("GetOrLoadStale should serve the stale value", stale, v, err)
+	}
+	<-failed
+	time.Sleep(20 * time.Millisecond)
+
+	loaded := make(chan struct{})
+	cc.GetOrLoadStale(context.Background(), "key1", time.Minute, func() (interface{}, error) {
+		defer close(loaded)
+		return "v2", nil
+	}, &v)
+	<-loaded
+	time.Sleep(20 * time.Millisecond)
+
+	if stale, err := cc.GetObjectStale("key1", &v); err != nil || stale || v != "v2" {
+		t.Error("value should be refreshed", stale, v, err)
+	}
+	if st := cc.Snapshot(); st.Stale != 3 {
+		t.Error("stale reads error", st.Stale)
+	}
+}
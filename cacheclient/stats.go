@@ -0,0 +1,331 @@
+package cacheclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// op is a kind of call counted in Stats.Ops
+type op int
+
+const (
+	opGet op = iota
+	opSet
+	opDel
+	opGets
+	opSets
+	numOps
+)
+
+var opNames = [numOps]string{"Get", "Set", "Del", "Gets", "Sets"}
+
+type opCounters struct {
+	calls    int64
+	errors   int64
+	timeouts int64
+	elapse   int64
+	latency  histogram
+}
+
+// OpStats is the stats of one operation, Gets and Sets count one call per
+// batch
+type OpStats struct {
+	Calls    int64
+	Errors   int64 `json:",omitempty"`
+	Timeouts int64 `json:",omitempty"`
+	// Elapse is the total time of the calls, Rt is the mean in ms
+	Elapse  time.Duration
+	Rt      float64
+	Latency Latency
+
+	latency *histCounts
+}
+
+// ShardStats is the stats of the single key calls served by one shard
+type ShardStats struct {
+	Calls    int64
+	Errors   int64 `json:",omitempty"`
+	Timeouts int64 `json:",omitempty"`
+	Elapse   time.Duration
+	Latency  Latency
+	// Breaker is the state of the shard breaker: closed, open or half-open,
+	// Rejected is the calls failed by it with ErrShardUnavailable
+	Breaker  string `json:",omitempty"`
+	Rejected int64  `json:",omitempty"`
+
+	latency *histCounts
+}
+
+// shardCounters count the calls of a shard, Gets and Sets span shards and
+// are not counted
+type shardCounters struct {
+	calls    int64
+	errors   int64
+	timeouts int64
+	elapse   int64
+	latency  histogram
+}
+
+// count add a call of o to shard started at start, redis.Nil is a miss
+// but not an error. shard is "" for batches. The call is also recorded by
+// the breaker of shard.
+func (cc *CacheClient) count(o op, shard string, start time.Time, err error) {
+	d := time.Since(start)
+	c := &cc.stats.ops[o]
+	atomic.AddInt64(&c.elapse, int64(d))
+	atomic.AddInt64(&c.calls, 1)
+	c.latency.add(d)
+
+	if shard != "" {
+		cc.countShard(shard, d, err)
+	}
+
+	if err == nil || err == redis.Nil {
+		return
+	}
+	atomic.AddInt64(&c.errors, 1)
+	if isTimeout(err) {
+		atomic.AddInt64(&c.timeouts, 1)
+	}
+}
+
+// countShard count a call of d to shard in its counters and its breaker
+func (cc *CacheClient) countShard(shard string, d time.Duration, err error) {
+	s := cc.shardCounters(shard)
+	atomic.AddInt64(&s.elapse, int64(d))
+	atomic.AddInt64(&s.calls, 1)
+	s.latency.add(d)
+	cc.record(shard, d, err)
+
+	if err == nil || err == redis.Nil {
+		return
+	}
+	atomic.AddInt64(&s.errors, 1)
+	if isTimeout(err) {
+		atomic.AddInt64(&s.timeouts, 1)
+	}
+}
+
+func (cc *CacheClient) shardCounters(shard string) *shardCounters {
+	cc.stats.shardMu.RLock()
+	s, ok := cc.stats.shards[shard]
+	cc.stats.shardMu.RUnlock()
+	if ok {
+		return s
+	}
+
+	cc.stats.shardMu.Lock()
+	defer cc.stats.shardMu.Unlock()
+	if s, ok = cc.stats.shards[shard]; !ok {
+		if cc.stats.shards == nil {
+			cc.stats.shards = make(map[string]*shardCounters)
+		}
+		s = new(shardCounters)
+		cc.stats.shards[shard] = s
+	}
+	return s
+}
+
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// Snapshot return the stats since the client is created, nothing is reset
+// so callers do not affect each other
+func (cc *CacheClient) Snapshot() Stats {
+	st := Stats{
+		StartTime: atomic.LoadInt64(&cc.stats.timeStart),
+		EndTime:   time.Now().UnixNano(),
+		Ops:       make(map[string]OpStats, numOps),
+	}
+
+	l1Hits := atomic.LoadUint64(&cc.stats.l1Hits)
+	hits := atomic.LoadUint64(&cc.stats.hits)
+	misses := atomic.LoadUint64(&cc.stats.misses)
+	st.Hits = l1Hits + hits
+	st.Misses = misses
+	st.Stale = atomic.LoadUint64(&cc.stats.stale)
+	st.EarlyRefreshes = atomic.LoadUint64(&cc.stats.earlyRefreshes)
+	st.TombstoneHits = atomic.LoadUint64(&cc.stats.tombstoneHits)
+	if cc.nearCache() != nil {
+		st.L1Hits = l1Hits
+		st.L1Misses = atomic.LoadUint64(&cc.stats.l1Misses)
+		st.L2Hits = hits
+		st.L2Misses = misses
+	}
+
+	for i := range cc.stats.ops {
+		c := &cc.stats.ops[i]
+		st.Ops[opNames[i]] = OpStats{
+			Calls:    atomic.LoadInt64(&c.calls),
+			Errors:   atomic.LoadInt64(&c.errors),
+			Timeouts: atomic.LoadInt64(&c.timeouts),
+			Elapse:   time.Duration(atomic.LoadInt64(&c.elapse)),
+			latency:  c.latency.load(),
+		}
+	}
+
+	cc.stats.shardMu.RLock()
+	if len(cc.stats.shards) > 0 {
+		st.Shards = make(map[string]ShardStats, len(cc.stats.shards))
+	}
+	for shard, c := range cc.stats.shards {
+		st.Shards[shard] = ShardStats{
+			Calls:    atomic.LoadInt64(&c.calls),
+			Errors:   atomic.LoadInt64(&c.errors),
+			Timeouts: atomic.LoadInt64(&c.timeouts),
+			Elapse:   time.Duration(atomic.LoadInt64(&c.elapse)),
+			latency:  c.latency.load(),
+		}
+	}
+	cc.stats.shardMu.RUnlock()
+	cc.breakerStats(&st)
+
+	compressIn := atomic.LoadInt64(&cc.stats.compressIn)
+	compressOut := atomic.LoadInt64(&cc.stats.compressOut)
+	if compressIn > 0 {
+		st.CompressRatio = float64(compressOut) / float64(compressIn)
+		st.BytesSaved = compressIn - compressOut
+	}
+
+	if cc.hotKeyDetector() != nil {
+		n := cc.config().HotKeys.TopN
+		if n == 0 {
+			n = defaultHotKeysTopN
+		}
+		st.HotKeys = cc.HotKeys(n)
+	}
+
+	st.finish()
+	return st
+}
+
+// Sub return the stats between prev and s, two snapshots of the same
+// client. CompressRatio, BytesSaved and HotKeys are taken from s.
+func (s Stats) Sub(prev Stats) Stats {
+	st := s
+	st.StartTime = prev.EndTime
+	st.Hits -= prev.Hits
+	st.Misses -= prev.Misses
+	st.Stale -= prev.Stale
+	st.EarlyRefreshes -= prev.EarlyRefreshes
+	st.TombstoneHits -= prev.TombstoneHits
+	st.L1Hits -= prev.L1Hits
+	st.L1Misses -= prev.L1Misses
+	st.L2Hits -= prev.L2Hits
+	st.L2Misses -= prev.L2Misses
+
+	st.Ops = make(map[string]OpStats, len(s.Ops))
+	for name, o := range s.Ops {
+		p := prev.Ops[name]
+		st.Ops[name] = OpStats{
+			Calls:    o.Calls - p.Calls,
+			Errors:   o.Errors - p.Errors,
+			Timeouts: o.Timeouts - p.Timeouts,
+			Elapse:   o.Elapse - p.Elapse,
+			latency:  o.latency.sub(p.latency),
+		}
+	}
+
+	st.Shards = nil
+	if len(s.Shards) > 0 {
+		st.Shards = make(map[string]ShardStats, len(s.Shards))
+	}
+	for shard, o := range s.Shards {
+		p := prev.Shards[shard]
+		st.Shards[shard] = ShardStats{
+			Calls:    o.Calls - p.Calls,
+			Errors:   o.Errors - p.Errors,
+			Timeouts: o.Timeouts - p.Timeouts,
+			Elapse:   o.Elapse - p.Elapse,
+			Breaker:  o.Breaker,
+			Rejected: o.Rejected - p.Rejected,
+			latency:  o.latency.sub(p.latency),
+		}
+	}
+
+	st.finish()
+	return st
+}
+
+// finish compute the totals and ratios from the counters
+func (s *Stats) finish() {
+	s.Requests, s.Errors, s.Timeouts = 0, 0, 0
+	s.HitRatio, s.Rt, s.QPS = 0, 0, 0
+
+	var elapse time.Duration
+	latency := new(histCounts)
+	for name, o := range s.Ops {
+		if o.Calls > 0 {
+			o.Rt = float64(o.Elapse) / float64(o.Calls) / 1e6
+		} else {
+			o.Rt = 0
+		}
+		o.Latency = Latency{}
+		if o.latency != nil {
+			o.Latency = o.latency.latency()
+			latency.merge(o.latency)
+		}
+		s.Ops[name] = o
+
+		s.Requests += o.Calls
+		s.Errors += o.Errors
+		s.Timeouts += o.Timeouts
+		elapse += o.Elapse
+	}
+
+	s.Latency = latency.latency()
+
+	for shard, o := range s.Shards {
+		o.Latency = Latency{}
+		if o.latency != nil {
+			o.Latency = o.latency.latency()
+		}
+		s.Shards[shard] = o
+	}
+
+	if s.Hits+s.Misses > 0 {
+		s.HitRatio = float64(s.Hits) * 100 / float64(s.Hits+s.Misses)
+	}
+	if s.Requests > 0 {
+		// ms
+		s.Rt = float64(elapse) / float64(s.Requests) / 1e6
+	}
+	if interval := s.EndTime - s.StartTime; interval > 0 {
+		s.QPS = int(s.Requests * 1e9 / interval)
+	}
+}
+
+// StatsWindow compute the stats between two calls of Next, each caller
+// keeps its own window
+type StatsWindow struct {
+	cc   *CacheClient
+	mu   sync.Mutex
+	prev Stats
+}
+
+// NewStatsWindow return a window starting now
+func (cc *CacheClient) NewStatsWindow() *StatsWindow {
+	return &StatsWindow{cc: cc, prev: cc.Snapshot()}
+}
+
+// Next return the stats since the last call, or since the window is
+// created
+func (w *StatsWindow) Next() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cur := w.cc.Snapshot()
+	st := cur.Sub(w.prev)
+	w.prev = cur
+	return st
+}
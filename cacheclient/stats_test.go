@@ -0,0 +1,95 @@
+package cacheclient
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// server is down, calls are counted as errors
+func Test_Snapshot(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	w := cc.NewStatsWindow()
+	cc.Get("key1")
+	cc.Get("key2")
+	cc.SetWithTTL("key1", "v1", time.Minute)
+	cc.Gets([]string{"key1", "key2"})
+
+	st := cc.Snapshot()
+	if st.Ops["Get"].Calls != 2 || st.Ops["Get"].Errors != 2 || st.Ops["Set"].Calls != 1 || st.Ops["Gets"].Calls != 1 {
+		t.Error("Snapshot ops error", st.Ops)
+	}
+	if st.Requests != 4 || st.Errors != 4 || st.Misses != 0 {
+		t.Error("Snapshot totals error", st)
+	}
+	if st.Shards["127.0.0.1:1"].Calls != 3 || st.Shards["127.0.0.1:1"].Errors != 3 {
+		t.Error("Snapshot shards error", st.Shards)
+	}
+	if st.Latency.P999 < st.Latency.P50 {
+		t.Error("Snapshot latency error", st.Latency)
+	}
+
+	// GetStats and Snapshot reset nothing
+	cc.GetStats()
+	if st = cc.Snapshot(); st.Requests != 4 {
+		t.Error("Snapshot should be cumulative", st.Requests)
+	}
+
+	cc.Del("key1")
+	st = w.Next()
+	if st.Requests != 5 || st.Ops["Del"].Calls != 1 {
+		t.Error("first window error", st)
+	}
+	if st = w.Next(); st.Requests != 0 {
+		t.Error("second window should be empty", st)
+	}
+
+	var obj Stats
+	json.Unmarshal([]byte(cc.GetStats()), &obj)
+	if obj.Requests != 1 || obj.Ops["Del"].Calls != 1 || obj.Shards["127.0.0.1:1"].Calls != 1 {
+		t.Error("GetStats window error", obj)
+	}
+}
+
+func Test_Stats_Sub(t *testing.T) {
+	prev := Stats{
+		EndTime: int64(time.Second),
+		Hits:    10,
+		Misses:  10,
+		Ops:     map[string]OpStats{"Get": {Calls: 20, Elapse: 20 * time.Millisecond}},
+	}
+	cur := Stats{
+		EndTime: int64(3 * time.Second),
+		Hits:    40,
+		Misses:  20,
+		Ops:     map[string]OpStats{"Get": {Calls: 60, Elapse: 100 * time.Millisecond}},
+	}
+
+	st := cur.Sub(prev)
+	if st.Hits != 30 || st.Misses != 10 || int(st.HitRatio) != 75 {
+		t.Error("Sub hits error", st)
+	}
+	// 40 calls in 2s, 80ms in total
+	if st.Requests != 40 || st.QPS != 20 || st.Rt != 2 || st.Ops["Get"].Rt != 2 {
+		t.Error("Sub requests error", st)
+	}
+}
+
+func Test_isTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if !isTimeout(ctx.Err()) {
+		t.Error("deadline should be a timeout")
+	}
+	if isTimeout(context.Canceled) {
+		t.Error("cancel should not be a timeout")
+	}
+}
@@ -0,0 +1,59 @@
+package cacheclient
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// policy return the TTL policy of key, the one of its longest prefix in
+// Prefixes. Its Default is the global Default when the prefix has none or
+// no prefix matches.
+func (c *TTLConfig) policy(key string) TTLPolicy {
+	var p TTLPolicy
+	found := -1
+	for prefix, pp := range c.Prefixes {
+		if len(prefix) > found && strings.HasPrefix(key, prefix) {
+			p, found = pp, len(prefix)
+		}
+	}
+	if p.Default <= 0 {
+		p.Default = c.Default
+	}
+	return p
+}
+
+// ttlFor return the ttl of a write of key by the TTL policy: the policy
+// default when ttl is 0, or max without a default, then moved by the
+// jitter and kept between the policy min and max. A key without a ttl
+// never expires only when neither is set.
+func (cc *CacheClient) ttlFor(key string, ttl time.Duration) time.Duration {
+	c := cc.config().TTL
+	p := c.policy(key)
+
+	if ttl <= 0 {
+		ttl = time.Duration(p.Default)
+	}
+	if ttl <= 0 {
+		ttl = time.Duration(p.Max)
+	}
+	if ttl <= 0 {
+		return 0
+	}
+
+	if c.Jitter > 0 {
+		// uniform in [-Jitter, Jitter)
+		ttl += time.Duration(float64(ttl) * c.Jitter * (2*rand.Float64() - 1))
+	}
+	if p.Min > 0 && ttl < time.Duration(p.Min) {
+		ttl = time.Duration(p.Min)
+	}
+	if p.Max > 0 && ttl > time.Duration(p.Max) {
+		ttl = time.Duration(p.Max)
+	}
+	// a ttl below 1ms is no expire for SET
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return ttl
+}
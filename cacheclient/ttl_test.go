@@ -0,0 +1,92 @@
+package cacheclient
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_ttlFor(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cfg.TTL.Default = Duration(time.Hour)
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithTTLJitter(0.1),
+		WithTTLPolicy("user:", 10*time.Minute, time.Minute, time.Hour),
+		WithTTLPolicy("user:vip:", 0, 0, 2*time.Hour))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	in := func(d, min, max time.Duration) bool {
+		return d >= min && d <= max
+	}
+	if ttl := cc.ttlFor("user:1", 0); !in(ttl, 9*time.Minute, 11*time.Minute) {
+		t.Error("key without ttl should get the prefix default", ttl)
+	}
+	if ttl := cc.ttlFor("user:1", 2*time.Hour); ttl != time.Hour {
+		t.Error("ttl should be kept below max", ttl)
+	}
+	if ttl := cc.ttlFor("user:1", 10*time.Second); ttl != time.Minute {
+		t.Error("ttl should be kept above min", ttl)
+	}
+	if ttl := cc.ttlFor("user:vip:1", 0); !in(ttl, 54*time.Minute, 66*time.Minute) {
+		t.Error("longest prefix without default should get the global default with jitter", ttl)
+	}
+	if ttl := cc.ttlFor("order:1", 0); !in(ttl, 54*time.Minute, 66*time.Minute) {
+		t.Error("key matching no prefix should get the default", ttl)
+	}
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		seen[cc.ttlFor("order:1", time.Minute)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("ttl should be moved by the jitter", seen)
+	}
+}
+
+func Test_ttlFor_NoPolicy(t *testing.T) {
+	cfg, _ := LoadConfig("redis.json")
+	cc, err := NewCacheClientWithConfig(cfg, WithHashType(HashTypeSingle), WithAddrs("127.0.0.1:1"),
+		WithTTLPolicy("session:", 0, 0, 10*time.Minute))
+	if err != nil {
+		t.Fatal("NewCacheClientWithConfig error", err)
+	}
+	defer cc.Close()
+
+	if ttl := cc.ttlFor("key1", 0); ttl != 0 {
+		t.Error("key without ttl should not expire", ttl)
+	}
+	if ttl := cc.ttlFor("session:1", 0); ttl != 10*time.Minute {
+		t.Error("key without any default should get max", ttl)
+	}
+	if ttl := cc.ttlFor("key1", time.Minute); ttl != time.Minute {
+		t.Error("ttl should be kept", ttl)
+	}
+}
+
+// keys set together do not expire together
+func Test_SetsWithTTL_Jitter(t *testing.T) {
+	cc, srv := newTestClient(t, WithTTLJitter(0.2), WithTTLPolicy("user:", time.Hour, 0, 0))
+
+	kvs := make(map[string]interface{})
+	for i := 0; i < 10; i++ {
+		kvs["user:"+strconv.Itoa(i)] = "v"
+	}
+	if err := cc.SetsWithTTL(kvs, 0); err != nil {
+		t.Fatal("SetsWithTTL error", err)
+	}
+
+	seen := make(map[time.Duration]bool)
+	for key := range kvs {
+		ttl := srv.ttl(key).Round(time.Second)
+		if ttl < 47*time.Minute || ttl > 73*time.Minute {
+			t.Error("ttl out of the jitter", key, ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Error("keys should get different ttls", seen)
+	}
+}
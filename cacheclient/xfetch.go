@@ -0,0 +1,66 @@
+package cacheclient
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// XFetch refreshes a value before it expires, with a probability rising as
+// the expiry nears and with the time the loader took (delta). A read
+// refreshes when now - delta * beta * ln(rand) >= expiry, so keys expensive
+// to load start early and a popular key is refreshed by one of its readers
+// instead of all of them at expiry. beta 1 is the usual choice, above 1
+// refreshes earlier.
+
+// xfetchEnvelopeID is the header byte of a value with its expiry and
+// recompute time, 8 bytes each before the encoded object
+const xfetchEnvelopeID byte = 0x0e
+
+const xfetchHeaderLen = 17
+
+// sealXFetch put data, an encoded object, in an envelope with its expiry
+// and recompute time
+func sealXFetch(data []byte, expiry time.Time, delta time.Duration) []byte {
+	b := make([]byte, xfetchHeaderLen, xfetchHeaderLen+len(data))
+	b[0] = xfetchEnvelopeID
+	binary.BigEndian.PutUint64(b[1:], uint64(expiry.UnixNano()))
+	binary.BigEndian.PutUint64(b[9:], uint64(delta))
+	return append(b, data...)
+}
+
+// refreshEarly tell if a read at now refreshes a value expiring at expiry
+// and taking delta to load
+func refreshEarly(now, expiry time.Time, delta time.Duration, beta float64) bool {
+	if beta <= 0 || delta <= 0 || expiry.IsZero() {
+		return false
+	}
+	// 1 - Float64 is in (0, 1], ln of it is <= 0
+	gap := -float64(delta) * beta * math.Log(1-rand.Float64())
+	return !now.Add(time.Duration(gap)).Before(expiry)
+}
+
+// LoadOption change one call of GetOrLoad
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	beta float64
+}
+
+// WithEarlyRefresh refresh the value early with XFetch and beta, it
+// overrides Config.Loader.Beta. 0 disables the early refresh.
+func WithEarlyRefresh(beta float64) LoadOption {
+	return func(o *loadOptions) {
+		o.beta = beta
+	}
+}
+
+// loadOptions return the options of a call on top of the config
+func (cc *CacheClient) loadOptions(opts []LoadOption) loadOptions {
+	o := loadOptions{beta: cc.config().Loader.Beta}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
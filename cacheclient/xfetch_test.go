@@ -0,0 +1,84 @@
+package cacheclient
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_refreshEarly(t *testing.T) {
+	now := time.Now()
+	if refreshEarly(now, now.Add(-time.Second), time.Second, 0) {
+		t.Error("beta 0 should disable the early refresh")
+	}
+	if !refreshEarly(now, now.Add(-time.Second), time.Second, 1) {
+		t.Error("expired value should be refreshed")
+	}
+	if refreshEarly(now, now.Add(time.Hour), time.Millisecond, 1) {
+		t.Error("value far from expiry should not be refreshed")
+	}
+
+	// P(-ln(U) >= 1) = 1/e
+	var n int
+	for i := 0; i < 10000; i++ {
+		if refreshEarly(now, now.Add(time.Second), time.Second, 1) {
+			n++
+		}
+	}
+	if n < 3000 || n > 4500 {
+		t.Error("early refresh probability error", n)
+	}
+}
+
+func Test_sealXFetch(t *testing.T) {
+	b, _ := encodeObject(JSONCodec, "v")
+	expiry := time.Unix(0, time.Now().UnixNano())
+
+	data, e, delta := openEnvelope(sealXFetch(b, expiry, 3*time.Millisecond))
+	if string(data) != string(b) || !e.Equal(expiry) || delta != 3*time.Millisecond {
+		t.Error("openEnvelope error", data, e, delta)
+	}
+}
+
+func Test_GetOrLoad_EarlyRefresh(t *testing.T) {
+	cc, srv := newTestClient(t)
+
+	loaded := make(chan string, 2)
+	loader := func(v string) Loader {
+		return func() (interface{}, error) {
+			time.Sleep(10 * time.Millisecond)
+			loaded <- v
+			return v, nil
+		}
+	}
+
+	// a huge beta refreshes at once
+	var v string
+	err := cc.GetOrLoad(context.Background(), "key1", time.Minute, loader("v1"), &v, WithEarlyRefresh(1e9))
+	if err != nil || v != "v1" || <-loaded != "v1" {
+		t.Fatal("GetOrLoad error", v, err)
+	}
+	if ttl := srv.ttl("key1"); ttl < time.Minute-time.Second {
+		t.Error("ttl should not change", ttl)
+	}
+
+	err = cc.GetOrLoad(context.Background(), "key1", time.Minute, loader("v2"), &v, WithEarlyRefresh(1e9))
+	if err != nil || v != "v1" || <-loaded != "v2" {
+		t.Fatal("GetOrLoad should return the value and refresh it", v, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	err = cc.GetOrLoad(context.Background(), "key1", time.Minute, loader("v3"), &v, WithEarlyRefresh(0))
+	if err != nil || v != "v2" {
+		t.Error("GetOrLoad should return the refreshed value", v, err)
+	}
+	select {
+	case v = <-loaded:
+		t.Error("beta 0 should not refresh", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if st := cc.Snapshot(); st.EarlyRefreshes != 1 {
+		t.Error("early refreshes error", st.EarlyRefreshes)
+	}
+}
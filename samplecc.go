@@ -9,19 +9,22 @@ import (
 	"samplecc/cacheclient"
 )
 
-func pushStats(st string) {
-	log.Printf(st)
+func pushStats(st cacheclient.Stats) error {
+	log.Printf("qps:%d hitRatio:%.2f rt:%.3fms p99:%dus", st.QPS, st.HitRatio, st.Rt, st.Latency.P99)
+	return nil
 }
 
 func main() {
-	cacheclient.InitPackage("cacheclient/redis.json")
-
-	cc, err := cacheclient.NewCacheClient()
+	cfg, err := cacheclient.LoadConfig("cacheclient/redis.json")
 	if err != nil {
-		log.Printf("NewCacheClient error:%s", err.Error())
+		log.Fatalf("LoadConfig error:%s", err.Error())
 	}
 
-	start := time.Now().UnixNano() / 1e6
+	cc, err := cacheclient.NewCacheClientWithConfig(cfg, cacheclient.WithStatsInterval(10*time.Second))
+	if err != nil {
+		log.Fatalf("NewCacheClientWithConfig error:%s", err.Error())
+	}
+	cc.AddStatsSink(cacheclient.StatsSinkFunc(pushStats))
 
 	for true {
 		r := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -30,10 +33,5 @@ func main() {
 			cc.GetString(strconv.Itoa(r.Intn(1000)))
 		}
 		time.Sleep(1 * time.Second)
-
-		if time.Now().UnixNano()/1e6-start > 10*1000 {
-			start = time.Now().UnixNano() / 1e6
-			pushStats(cc.GetStats())
-		}
 	}
 }